@@ -0,0 +1,390 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/braheezy/qoa"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze <file>",
+	Short: "Report per-frame encoder statistics",
+	Long: `Report per-frame and per-slice statistics of a QOA encode: the scale factors, LMS
+weights and residual energy, and the coding error when the source audio is known.
+
+A QOA file is analyzed as is, and --source gives the audio it was encoded from.
+Any other supported file is encoded to QOA in memory and analyzed against itself.
+
+Frames where the error spikes above the typical error of the file are flagged, along
+with the likely cause: transients or high-frequency content.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		inputFile := args[0]
+		sourceFile, _ := cmd.Flags().GetString("source")
+		format, _ := cmd.Flags().GetString("format")
+		withSlices, _ := cmd.Flags().GetBool("slices")
+		spikeFactor, _ := cmd.Flags().GetFloat64("spike-factor")
+
+		if format != "table" && format != "json" {
+			logger.Fatalf("Unknown format %q, expected table or json", format)
+		}
+		if format == "json" && !quiet {
+			// Keep log messages out of the JSON
+			logger.SetOutput(os.Stderr)
+		}
+
+		var encoded []byte
+		var source []int16
+		var sourceFormat *qoa.QOA
+		if hasQOAExtension(inputFile) {
+			var err error
+			if encoded, err = os.ReadFile(inputFile); err != nil {
+				logger.Fatalf("Error reading %s: %v", inputFile, err)
+			}
+			if sourceFile != "" {
+				if source, sourceFormat, err = decodeAudio(sourceFile); err != nil {
+					logger.Fatalf("Error decoding %s: %v", sourceFile, err)
+				}
+			}
+		} else {
+			decodedData, q, err := decodeAudio(inputFile)
+			if err != nil {
+				logger.Fatalf("Error decoding %s: %v", inputFile, err)
+			}
+			if encoded, err = encodeQOA(q, decodedData); err != nil {
+				logger.Fatalf("Error encoding %s: %v", inputFile, err)
+			}
+			source, sourceFormat = decodedData, q
+		}
+
+		a, err := analyzeQOA(encoded, source, sourceFormat, spikeFactor)
+		if err != nil {
+			logger.Fatalf("Error analyzing %s: %v", inputFile, err)
+		}
+		a.File = inputFile
+
+		if !withSlices {
+			for i := range a.Frames {
+				a.Frames[i].Slices = nil
+			}
+		}
+		if format == "json" {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(a); err != nil {
+				logger.Fatalf("Error writing JSON: %v", err)
+			}
+			return
+		}
+		fmt.Fprint(cmd.OutOrStdout(), a.table())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(analyzeCmd)
+	analyzeCmd.Flags().StringP("source", "s", "", "Source audio a QOA file was encoded from, to measure the coding error")
+	analyzeCmd.Flags().String("format", "table", "Output format: table or json")
+	analyzeCmd.Flags().Bool("slices", false, "Include per-slice statistics")
+	analyzeCmd.Flags().Float64("spike-factor", 4, "Flag frames whose error is this many times the median frame error")
+}
+
+// qoaDequantized is from the QOA specification. The dequantized values are the residuals the
+// decoder adds to its prediction, for each scale factor and quantized value.
+var qoaDequantized = func() [16][8]int {
+	dqt := [8]float64{0.75, -0.75, 2.5, -2.5, 4.5, -4.5, 7, -7}
+	var table [16][8]int
+	for s := range table {
+		scaleFactor := math.Round(math.Pow(float64(s+1), 2.75))
+		for q := range table[s] {
+			// Rounded to nearest, ties away from zero
+			table[s][q] = int(math.Round(scaleFactor * dqt[q]))
+		}
+	}
+	return table
+}()
+
+// qoaAnalysis is the timeline of statistics of a QOA file.
+type qoaAnalysis struct {
+	File       string  `json:"file"`
+	SampleRate uint32  `json:"sample_rate"`
+	Channels   uint32  `json:"channels"`
+	Samples    uint32  `json:"samples"`
+	HasSource  bool    `json:"has_source"`
+	PSNR       float64 `json:"psnr,omitempty"`
+	// ScaleFactors is the histogram of scale factors of all slices
+	ScaleFactors [16]int      `json:"scale_factors"`
+	Frames       []frameStats `json:"frames"`
+}
+
+type frameStats struct {
+	Index   int     `json:"index"`
+	Start   float64 `json:"start"`
+	Samples uint32  `json:"samples"`
+	// ScaleFactors is the histogram of scale factors of the slices in this frame
+	ScaleFactors    [16]int `json:"scale_factors"`
+	MeanScaleFactor float64 `json:"mean_scale_factor"`
+	// Weights are the LMS weights of each channel at the start of the frame
+	Weights   [][4]int16 `json:"lms_weights"`
+	MaxWeight int        `json:"max_weight"`
+	// ResidualEnergy is the mean energy of the dequantized residuals, in dBFS
+	ResidualEnergy float64 `json:"residual_energy_db"`
+	// The error against the source, only known when the source is
+	ErrorRMS float64      `json:"error_rms,omitempty"`
+	MaxError int          `json:"max_error,omitempty"`
+	PSNR     float64      `json:"psnr,omitempty"`
+	Flags    []string     `json:"flags,omitempty"`
+	Slices   []sliceStats `json:"slices,omitempty"`
+}
+
+type sliceStats struct {
+	Index          int     `json:"index"`
+	Channel        int     `json:"channel"`
+	ScaleFactor    int     `json:"scale_factor"`
+	ResidualEnergy float64 `json:"residual_energy_db"`
+	ErrorRMS       float64 `json:"error_rms,omitempty"`
+}
+
+// analyzeQOA gathers the statistics of every frame of an encoded file. source is the audio it was
+// encoded from, or nil if unknown, and sourceFormat its sample rate and channels.
+func analyzeQOA(encoded []byte, source []int16, sourceFormat *qoa.QOA, spikeFactor float64) (*qoaAnalysis, error) {
+	q, frames, err := parseQOAFrames(encoded)
+	if err != nil {
+		return nil, err
+	}
+	channels := int(q.Channels)
+	if source != nil {
+		if sourceFormat.SampleRate != q.SampleRate || sourceFormat.Channels != q.Channels {
+			return nil, fmt.Errorf("source is %d Hz with %d channels, the QOA file %d Hz with %d channels",
+				sourceFormat.SampleRate, sourceFormat.Channels, q.SampleRate, q.Channels)
+		}
+		if len(source) < int(q.Samples)*channels {
+			return nil, fmt.Errorf("source has %d samples per channel, the QOA file %d", len(source)/channels, q.Samples)
+		}
+	}
+
+	a := &qoaAnalysis{SampleRate: q.SampleRate, Channels: q.Channels, Samples: q.Samples, HasSource: source != nil}
+	totalSquaredError := 0.0
+	start := 0
+	for i, f := range frames {
+		stats := frameStats{
+			Index:   i,
+			Start:   float64(start) / float64(q.SampleRate),
+			Samples: f.samples,
+			Weights: make([][4]int16, channels),
+		}
+
+		// LMS state of every channel follows the frame header
+		p := qoaFrameHeaderSize
+		for c := 0; c < channels; c++ {
+			for w := 0; w < qoa.QOALMSLen; w++ {
+				stats.Weights[c][w] = int16(binary.BigEndian.Uint16(f.data[p+8+w*2:]))
+				stats.MaxWeight = max(stats.MaxWeight, abs(int(stats.Weights[c][w])))
+			}
+			p += qoa.QOALMSLen * 4
+		}
+
+		var decoded []int16
+		if source != nil {
+			if decoded, err = decodeQOAFrame(f); err != nil {
+				return nil, fmt.Errorf("frame %d: %w", i, err)
+			}
+		}
+
+		residualEnergy, residualCount := 0.0, 0
+		frameSquaredError := 0.0
+		sliceCount := 0
+		for sampleIndex := 0; sampleIndex < int(f.samples); sampleIndex += qoa.QOASliceLen {
+			sliceLen := min(qoa.QOASliceLen, int(f.samples)-sampleIndex)
+			for c := 0; c < channels; c++ {
+				slice := binary.BigEndian.Uint64(f.data[p:])
+				p += 8
+
+				sf := int(slice >> 60)
+				stats.ScaleFactors[sf]++
+				a.ScaleFactors[sf]++
+				stats.MeanScaleFactor += float64(sf)
+				sliceCount++
+
+				sliceEnergy := 0.0
+				for s := 0; s < sliceLen; s++ {
+					r := float64(qoaDequantized[sf][(slice>>(57-3*s))&0x7])
+					sliceEnergy += r * r
+				}
+				residualEnergy += sliceEnergy
+				residualCount += sliceLen
+
+				ss := sliceStats{Index: sampleIndex / qoa.QOASliceLen, Channel: c, ScaleFactor: sf, ResidualEnergy: toDBFS(sliceEnergy / float64(sliceLen))}
+				if source != nil {
+					sliceSquaredError := 0.0
+					for s := sampleIndex; s < sampleIndex+sliceLen; s++ {
+						e := int(source[(start+s)*channels+c]) - int(decoded[s*channels+c])
+						sliceSquaredError += float64(e * e)
+						stats.MaxError = max(stats.MaxError, abs(e))
+					}
+					frameSquaredError += sliceSquaredError
+					ss.ErrorRMS = math.Sqrt(sliceSquaredError / float64(sliceLen))
+				}
+				stats.Slices = append(stats.Slices, ss)
+			}
+		}
+		stats.MeanScaleFactor /= float64(sliceCount)
+		stats.ResidualEnergy = toDBFS(residualEnergy / float64(residualCount))
+
+		if source != nil {
+			n := float64(int(f.samples) * channels)
+			stats.ErrorRMS = math.Sqrt(frameSquaredError / n)
+			stats.PSNR = psnr(frameSquaredError / n)
+			totalSquaredError += frameSquaredError
+		}
+
+		a.Frames = append(a.Frames, stats)
+		start += int(f.samples)
+	}
+
+	if source != nil {
+		a.PSNR = psnr(totalSquaredError / float64(int(q.Samples)*channels))
+	}
+	a.flagSpikes(source, spikeFactor)
+	return a, nil
+}
+
+// flagSpikes flags frames whose error, or without a source their residual energy, is far above
+// the median of the file, and guesses the cause from the source audio.
+func (a *qoaAnalysis) flagSpikes(source []int16, spikeFactor float64) {
+	if len(a.Frames) == 0 {
+		return
+	}
+	level := func(f frameStats) float64 {
+		if source != nil {
+			return f.ErrorRMS
+		}
+		return math.Pow(10, f.ResidualEnergy/20)
+	}
+	levels := make([]float64, len(a.Frames))
+	for i, f := range a.Frames {
+		levels[i] = level(f)
+	}
+	sort.Float64s(levels)
+	median := levels[len(levels)/2]
+
+	channels := int(a.Channels)
+	prevEnergy := math.Inf(1)
+	start := 0
+	for i := range a.Frames {
+		f := &a.Frames[i]
+		end := start + int(f.Samples)
+
+		// Compare the source loudness and its high-frequency content to find the cause
+		energy, diffEnergy := 0.0, 0.0
+		if source != nil {
+			for s := start; s < end; s++ {
+				for c := 0; c < channels; c++ {
+					v := float64(source[s*channels+c])
+					energy += v * v
+					if s > 0 {
+						d := v - float64(source[(s-1)*channels+c])
+						diffEnergy += d * d
+					}
+				}
+			}
+		}
+
+		if median > 0 && level(*f) > median*spikeFactor {
+			f.Flags = append(f.Flags, "spike")
+			if source != nil {
+				if energy > prevEnergy*16 {
+					f.Flags = append(f.Flags, "transient")
+				}
+				// The first difference boosts high frequencies, from 0 at DC to 4x at Nyquist
+				if energy > 0 && diffEnergy/energy > 1 {
+					f.Flags = append(f.Flags, "high-frequency")
+				}
+			}
+		}
+		prevEnergy = energy
+		start = end
+	}
+}
+
+func toDBFS(meanSquare float64) float64 {
+	return 10 * math.Log10(math.Max(meanSquare, 1e-10)/(32768*32768))
+}
+
+// psnr is the peak signal to noise ratio in dB for the mean squared error, as reported by convert.
+// Like toDBFS, it stays finite without error, so it can be written to JSON.
+func psnr(meanSquaredError float64) float64 {
+	return -toDBFS(meanSquaredError)
+}
+
+func abs[T int | int64](v T) T {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// table renders the analysis as a text table, followed by the scale factor histogram.
+func (a *qoaAnalysis) table() string {
+	var b strings.Builder
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(main)
+	flagStyle := lipgloss.NewStyle().Foreground(accent)
+
+	fmt.Fprintf(&b, "%s: %d Hz, %d channels, %s, %d frames\n", a.File, a.SampleRate, a.Channels,
+		formatDuration(samplesToDuration(a.Samples, a.SampleRate)), len(a.Frames))
+	if a.HasSource {
+		fmt.Fprintf(&b, "psnr: %.2f dB\n", a.PSNR)
+	}
+	b.WriteRune('\n')
+
+	header := fmt.Sprintf("%6s %9s %7s %7s %10s", "frame", "start", "mean sf", "max |w|", "residual")
+	if a.HasSource {
+		header += fmt.Sprintf(" %9s %8s %9s", "err rms", "max err", "psnr")
+	}
+	b.WriteString(headerStyle.Render(header + "  flags"))
+	b.WriteRune('\n')
+
+	for _, f := range a.Frames {
+		fmt.Fprintf(&b, "%6d %8.3fs %7.2f %7d %7.1f dB", f.Index, f.Start, f.MeanScaleFactor, f.MaxWeight, f.ResidualEnergy)
+		if a.HasSource {
+			fmt.Fprintf(&b, " %9.1f %8d %6.1f dB", f.ErrorRMS, f.MaxError, f.PSNR)
+		}
+		if len(f.Flags) > 0 {
+			b.WriteString("  " + flagStyle.Render(strings.Join(f.Flags, ", ")))
+		}
+		b.WriteRune('\n')
+
+		for _, s := range f.Slices {
+			fmt.Fprintf(&b, "%6s   slice %3d ch %d  sf %2d %7.1f dB", "", s.Index, s.Channel, s.ScaleFactor, s.ResidualEnergy)
+			if a.HasSource {
+				fmt.Fprintf(&b, "  err rms %.1f", s.ErrorRMS)
+			}
+			b.WriteRune('\n')
+		}
+	}
+
+	b.WriteRune('\n')
+	b.WriteString(headerStyle.Render("scale factors"))
+	b.WriteRune('\n')
+	most := 0
+	total := 0
+	for _, n := range a.ScaleFactors {
+		most = max(most, n)
+		total += n
+	}
+	for sf, n := range a.ScaleFactors {
+		bar := 0
+		if most > 0 {
+			bar = n * 40 / most
+		}
+		fmt.Fprintf(&b, "%6d %s %d (%.1f%%)\n", sf, strings.Repeat("█", bar), n, 100*float64(n)/float64(max(total, 1)))
+	}
+	return b.String()
+}
@@ -0,0 +1,321 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ebitengine/oto/v3"
+)
+
+// audioOutput is where the player sends its audio: the sound device, or a headless sink.
+// Like an oto.Context, there is one per process, with a fixed format.
+type audioOutput interface {
+	// NewPlayer creates a player reading 16-bit little-endian samples from r.
+	NewPlayer(r io.Reader) audioPlayer
+	SampleRate() int
+	ChannelCount() int
+	// Close stops all output and finishes writing it, if it goes to a file.
+	Close() error
+}
+
+// audioPlayer plays the samples of one source. *oto.Player implements it.
+type audioPlayer interface {
+	Play()
+	Pause()
+	IsPlaying() bool
+	// BufferedSize is the size in bytes of the samples read from the source but not heard yet.
+	BufferedSize() int
+	// Seek seeks the source, dropping any buffered samples.
+	Seek(offset int64, whence int) (int64, error)
+	// SetVolume sets the gain, from 0 to 1.
+	SetVolume(volume float64)
+	Volume() float64
+	Close() error
+}
+
+// audioOutputs describes the values of the play --output flag.
+const audioOutputs = "device, null, or a .wav file"
+
+// newAudioOutput opens the output named by the play --output flag. speed is how fast the headless
+// outputs run, as a multiple of real time. 0 runs them as fast as possible.
+func newAudioOutput(name string, sampleRate, channels int, speed float64) (audioOutput, error) {
+	switch {
+	case name == "device" || name == "":
+		return newDeviceOutput(sampleRate, channels)
+	case name == "null":
+		return newSinkOutput(io.Discard, sampleRate, channels, speed), nil
+	case filepath.Ext(name) == ".wav":
+		f, err := os.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		w, err := newWAVStreamWriter(f, sampleRate, channels)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return newSinkOutput(w, sampleRate, channels, speed), nil
+	default:
+		return nil, fmt.Errorf("unknown output %q, expected %s", name, audioOutputs)
+	}
+}
+
+// deviceOutput plays through the default sound device.
+type deviceOutput struct {
+	ctx        *oto.Context
+	sampleRate int
+	channels   int
+}
+
+func newDeviceOutput(sampleRate, channels int) (*deviceOutput, error) {
+	ctx, ready, err := oto.NewContext(&oto.NewContextOptions{
+		SampleRate: sampleRate,
+		// only 1 or 2 are supported by oto
+		ChannelCount: channels,
+		// QOA is always 16 bit
+		Format: oto.FormatSignedInt16LE,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening sound device: %w (use --output null to play without one)", err)
+	}
+	<-ready
+	return &deviceOutput{ctx: ctx, sampleRate: sampleRate, channels: channels}, nil
+}
+
+func (o *deviceOutput) NewPlayer(r io.Reader) audioPlayer { return o.ctx.NewPlayer(r) }
+func (o *deviceOutput) SampleRate() int                   { return o.sampleRate }
+func (o *deviceOutput) ChannelCount() int                 { return o.channels }
+func (o *deviceOutput) Close() error                      { return o.ctx.Suspend() }
+
+// sinkOutput plays without a sound device, mixing its players into a writer on its own clock.
+type sinkOutput struct {
+	sampleRate int
+	channels   int
+	// speed is the multiple of real time the clock runs at, 0 to run as fast as possible
+	speed float64
+
+	mu      sync.Mutex
+	w       io.Writer
+	players []*sinkPlayer
+	closed  bool
+	done    chan struct{}
+	err     error
+}
+
+// sinkChunk is the most sample frames mixed at a time.
+const sinkChunk = 1024
+
+func newSinkOutput(w io.Writer, sampleRate, channels int, speed float64) *sinkOutput {
+	o := &sinkOutput{sampleRate: sampleRate, channels: channels, speed: speed, w: w, done: make(chan struct{})}
+	go o.run()
+	return o
+}
+
+func (o *sinkOutput) NewPlayer(r io.Reader) audioPlayer {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p := &sinkPlayer{output: o, src: r, volume: 1}
+	o.players = append(o.players, p)
+	return p
+}
+
+func (o *sinkOutput) SampleRate() int   { return o.sampleRate }
+func (o *sinkOutput) ChannelCount() int { return o.channels }
+
+func (o *sinkOutput) Close() error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return o.err
+	}
+	o.closed = true
+	o.mu.Unlock()
+	<-o.done
+
+	if c, ok := o.w.(io.Closer); ok {
+		if err := c.Close(); err != nil && o.err == nil {
+			o.err = err
+		}
+	}
+	return o.err
+}
+
+// run is the output clock. It mixes whatever is playing, in real time unless the speed says otherwise.
+func (o *sinkOutput) run() {
+	defer close(o.done)
+	mix := make([]int32, sinkChunk*o.channels)
+	buf := make([]byte, sinkChunk*o.channels*2)
+	last := time.Now()
+	pending := 0.0
+
+	for {
+		frames := sinkChunk
+		if o.speed > 0 {
+			time.Sleep(5 * time.Millisecond)
+			now := time.Now()
+			pending += now.Sub(last).Seconds() * float64(o.sampleRate) * o.speed
+			last = now
+			frames = min(int(pending), sinkChunk)
+			pending -= float64(frames)
+		}
+
+		o.mu.Lock()
+		if o.closed {
+			o.mu.Unlock()
+			return
+		}
+		// Only as many samples as the longest player had are written, so the
+		// output ends where the audio does
+		mixed := 0
+		clear(mix[:frames*o.channels])
+		for _, p := range o.players {
+			mixed = max(mixed, p.mixInto(mix[:frames*o.channels], buf))
+		}
+		if mixed > 0 && o.err == nil {
+			for i, v := range mix[:mixed] {
+				binary.LittleEndian.PutUint16(buf[i*2:], uint16(clampInt16(int(v))))
+			}
+			if _, err := o.w.Write(buf[:mixed*2]); err != nil {
+				o.err = err
+			}
+		}
+		o.mu.Unlock()
+
+		if mixed == 0 && o.speed == 0 {
+			// Don't spin while there's nothing to play
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// sinkPlayer is a player of a sinkOutput. Like an oto player, it pauses at the end of its source.
+type sinkPlayer struct {
+	output  *sinkOutput
+	src     io.Reader
+	playing bool
+	closed  bool
+	volume  float64
+}
+
+// mixInto adds the next samples of the source to mix. buf is scratch space of the same byte size.
+// It is called with the output locked, and returns the number of samples mixed.
+func (p *sinkPlayer) mixInto(mix []int32, buf []byte) int {
+	if !p.playing || p.closed {
+		return 0
+	}
+	n, err := io.ReadFull(p.src, buf[:len(mix)*2])
+	for i := 0; i < n/2; i++ {
+		mix[i] += int32(float64(int16(binary.LittleEndian.Uint16(buf[i*2:]))) * p.volume)
+	}
+	if err != nil {
+		p.playing = false
+	}
+	return n / 2
+}
+
+func (p *sinkPlayer) Play() {
+	p.output.mu.Lock()
+	defer p.output.mu.Unlock()
+	p.playing = !p.closed
+}
+
+func (p *sinkPlayer) Pause() {
+	p.output.mu.Lock()
+	defer p.output.mu.Unlock()
+	p.playing = false
+}
+
+func (p *sinkPlayer) IsPlaying() bool {
+	p.output.mu.Lock()
+	defer p.output.mu.Unlock()
+	return p.playing
+}
+
+func (p *sinkPlayer) SetVolume(volume float64) {
+	p.output.mu.Lock()
+	defer p.output.mu.Unlock()
+	p.volume = volume
+}
+
+func (p *sinkPlayer) Volume() float64 {
+	p.output.mu.Lock()
+	defer p.output.mu.Unlock()
+	return p.volume
+}
+
+// BufferedSize is always 0, the sinks hear samples the moment they are read.
+func (p *sinkPlayer) BufferedSize() int { return 0 }
+
+func (p *sinkPlayer) Seek(offset int64, whence int) (int64, error) {
+	p.output.mu.Lock()
+	defer p.output.mu.Unlock()
+	s, ok := p.src.(io.Seeker)
+	if !ok {
+		return 0, errors.New("the source must implement io.Seeker")
+	}
+	return s.Seek(offset, whence)
+}
+
+func (p *sinkPlayer) Close() error {
+	p.output.mu.Lock()
+	defer p.output.mu.Unlock()
+	p.closed, p.playing = true, false
+	for i, q := range p.output.players {
+		if q == p {
+			p.output.players = append(p.output.players[:i], p.output.players[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// wavStreamWriter writes a WAV file of unknown length, fixing up the sizes in the header on Close.
+type wavStreamWriter struct {
+	f        *os.File
+	dataSize uint32
+}
+
+func newWAVStreamWriter(f *os.File, sampleRate, channels int) (*wavStreamWriter, error) {
+	header := make([]byte, 44)
+	copy(header, "RIFF")
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(header[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(sampleRate*channels*2))
+	binary.LittleEndian.PutUint16(header[32:], uint16(channels*2))
+	binary.LittleEndian.PutUint16(header[34:], 16)
+	copy(header[36:], "data")
+	if _, err := f.Write(header); err != nil {
+		return nil, err
+	}
+	return &wavStreamWriter{f: f}, nil
+}
+
+func (w *wavStreamWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.dataSize += uint32(n)
+	return n, err
+}
+
+func (w *wavStreamWriter) Close() error {
+	sizes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sizes, 36+w.dataSize)
+	if _, err := w.f.WriteAt(sizes, 4); err != nil {
+		w.f.Close()
+		return err
+	}
+	binary.LittleEndian.PutUint32(sizes, w.dataSize)
+	if _, err := w.f.WriteAt(sizes, 40); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
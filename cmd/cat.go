@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/braheezy/qoa"
+	"github.com/spf13/cobra"
+)
+
+var catCmd = &cobra.Command{
+	Use:   "cat <file.qoa>... -o <output.qoa>",
+	Short: "Join QOA files without re-encoding",
+	Long: `Join QOA files by copying their frames verbatim, so no quality is lost.
+
+All inputs must have the same sample rate and channel count.
+
+Every frame but the last of a QOA file must be full, so an input that ends with a
+partial frame can't be followed by frames copied as-is. --partial picks what to do:
+  reencode  re-encode everything after the partial frame (exact audio, lossy from that point)
+  pad       pad the partial frame with silence so later frames are still copied verbatim`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		outputFile, _ := cmd.Flags().GetString("output")
+		partial, _ := cmd.Flags().GetString("partial")
+		if partial != "reencode" && partial != "pad" {
+			logger.Fatalf("Unknown --partial mode: %s", partial)
+		}
+
+		inputs := make([][]qoaFrame, len(args))
+		var sampleRate, channels uint32
+		for i, inputFile := range args {
+			data, err := os.ReadFile(inputFile)
+			if err != nil {
+				logger.Fatalf("Error reading %s: %v", inputFile, err)
+			}
+			q, frames, err := parseQOAFrames(data)
+			if err != nil {
+				logger.Fatalf("Error parsing %s: %v", inputFile, err)
+			}
+			if i == 0 {
+				sampleRate, channels = q.SampleRate, q.Channels
+			} else if q.SampleRate != sampleRate || q.Channels != channels {
+				logger.Fatalf("%s is %d Hz with %d channels, but %s is %d Hz with %d channels",
+					inputFile, q.SampleRate, q.Channels, args[0], sampleRate, channels)
+			}
+			logger.Debug(inputFile, "frames", len(frames), "samples/channel", q.Samples)
+			inputs[i] = frames
+		}
+
+		frames, reencoded, err := joinQOAFrames(inputs, sampleRate, channels, partial == "pad")
+		if err != nil {
+			logger.Fatalf("Error joining frames: %v", err)
+		}
+		if reencoded > 0 {
+			logger.Warnf("Re-encoded %d frame(s) after a partial frame", reencoded)
+		}
+
+		f, err := os.Create(outputFile)
+		if err != nil {
+			logger.Fatalf("Error creating %s: %v", outputFile, err)
+		}
+		defer f.Close()
+		if err := writeQOAFrames(f, frames); err != nil {
+			logger.Fatalf("Error writing %s: %v", outputFile, err)
+		}
+
+		logger.Infof("Joined %d files -> %s (%d frames)", len(args), outputFile, len(frames))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(catCmd)
+	catCmd.Flags().StringP("output", "o", "", "Output QOA file")
+	catCmd.MarkFlagRequired("output")
+	catCmd.Flags().String("partial", "reencode", "How to handle inputs ending in a partial frame: reencode or pad")
+}
+
+// joinQOAFrames concatenates the frames of several inputs into a valid frame sequence.
+// Frames are copied verbatim while every frame before them is full. Once a partial frame
+// shows up before the end, the rest is either re-encoded or, with pad, the partial frame
+// is padded with silence. It returns the frames and how many of them were re-encoded.
+func joinQOAFrames(inputs [][]qoaFrame, sampleRate, channels uint32, pad bool) ([]qoaFrame, int, error) {
+	var out []qoaFrame
+	// pending holds decoded samples waiting to be re-encoded
+	var pending []int16
+	reencoded := 0
+
+	for i, frames := range inputs {
+		last := i == len(inputs)-1
+		for j, f := range frames {
+			endOfInput := j == len(frames)-1
+
+			if pending == nil && (f.full() || (last && endOfInput)) {
+				out = append(out, f)
+				continue
+			}
+
+			samples, err := decodeQOAFrame(f)
+			if err != nil {
+				return nil, 0, err
+			}
+			if pending == nil && pad {
+				samples = append(samples, make([]int16, (qoa.QOAFrameLen-int(f.samples))*int(channels))...)
+				padded, err := encodeQOAFrames(samples, sampleRate, channels)
+				if err != nil {
+					return nil, 0, err
+				}
+				out = append(out, padded...)
+				reencoded += len(padded)
+				continue
+			}
+			pending = append(pending, samples...)
+		}
+	}
+
+	if pending != nil {
+		frames, err := encodeQOAFrames(pending, sampleRate, channels)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, frames...)
+		reencoded += len(frames)
+	}
+
+	return out, reencoded, nil
+}
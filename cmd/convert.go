@@ -2,9 +2,10 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"os"
 	"path/filepath"
@@ -13,7 +14,6 @@ import (
 	"github.com/braheezy/qoa"
 	"github.com/go-audio/audio"
 	"github.com/go-audio/wav"
-	"github.com/jfreymuth/oggvorbis"
 	"github.com/mewkiz/flac"
 	"github.com/mewkiz/flac/frame"
 	"github.com/mewkiz/flac/meta"
@@ -24,12 +24,25 @@ import (
 var convertCmd = &cobra.Command{
 	Use:   "convert <input-file> <output-file>",
 	Short: "Convert between QOA and other audio formats",
-	Long:  fmt.Sprintf("Convert between QOA and other audio formats. The supported audio formats are:\n%v", strings.Join(supportedFormats, "\n")),
-	Args:  cobra.ExactArgs(2),
+	Long: fmt.Sprintf(`Convert between QOA and other audio formats. The supported audio formats are:
+%v
+
+.pcm and .raw files are headerless interleaved samples, described by --rate, --channels
+and --format. Use - as the input or output file to read raw samples from stdin, or
+write them to stdout.`, strings.Join(supportedFormats, "\n")),
+	Args: cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		inputFile := args[0]
 		outputFile := args[1]
 
+		if outputFile == stdioFilename && !quiet {
+			// Keep log messages out of the audio
+			logger.SetOutput(os.Stderr)
+		}
+		if err := rawPCM.validate(); err != nil {
+			logger.Fatal(err)
+		}
+
 		if isSupportedConversion(inputFile, outputFile) {
 			convertAudio(inputFile, outputFile)
 		} else {
@@ -39,16 +52,19 @@ var convertCmd = &cobra.Command{
 	DisableFlagsInUseLine: true,
 }
 
-var supportedFormats = []string{".qoa", ".wav", ".mp3", ".ogg", ".flac"}
+var supportedFormats = []string{".qoa", ".wav", ".mp3", ".ogg", ".flac", ".pcm", ".raw"}
 
 func init() {
 	rootCmd.AddCommand(convertCmd)
+	convertCmd.Flags().IntVar(&rawPCM.sampleRate, "rate", rawPCM.sampleRate, "Sample rate of raw input, in Hz")
+	convertCmd.Flags().IntVar(&rawPCM.channels, "channels", rawPCM.channels, "Number of channels of raw input")
+	convertCmd.Flags().StringVar(&rawPCM.encoding, "format", rawPCM.encoding, "Sample format of raw input and output: s16le, s16be, u8 or f32le")
 }
 
 // Function to check if the conversion is supported
 func isSupportedConversion(inputFile, outputFile string) bool {
-	inExt := filepath.Ext(inputFile)
-	outExt := filepath.Ext(outputFile)
+	inExt := audioExt(inputFile)
+	outExt := audioExt(outputFile)
 
 	notSameFileExt := inExt != outExt
 	bothSupportedExt := contains(supportedFormats, inExt) && contains(supportedFormats, outExt)
@@ -66,184 +82,54 @@ func contains(arr []string, target string) bool {
 	return false
 }
 
+// audioExt is the file extension that decides the audio format of a file. Stdin and stdout are raw PCM.
+func audioExt(filename string) string {
+	if filename == stdioFilename {
+		return ".raw"
+	}
+	return filepath.Ext(filename)
+}
+
 func hasQOAExtension(filename string) bool {
 	return filepath.Ext(filename) == ".qoa"
 }
 
 // Function to convert audio between formats
 func convertAudio(inputFile, outputFile string) {
-	// Load the input audio file
-	inputData, err := os.ReadFile(inputFile)
-	if err != nil {
-		logger.Fatalf("Error loading audio file: %v\n", err)
-	}
-
-	// For the given input file type, we will obtain these values.
 	// decodedData is the audio data converted to int16 (QOA format)
-	var decodedData []int16
 	// q is the QOA description. It is easiest created while decoding the input file.
-	var q *qoa.QOA
-
-	inExt := filepath.Ext(inputFile)
-	switch inExt {
-	case ".qoa":
-		logger.Info("Input format is QOA")
-		q, decodedData, err = qoa.Decode(inputData)
-		if err != nil {
-			log.Fatalf("Error decoding QOA data: %v", err)
-		}
-	case ".wav":
-		logger.Info("Input format is WAV")
-		wavReader := bytes.NewReader(inputData)
-		wavDecoder := wav.NewDecoder(wavReader)
-
-		// Read the WAV header to get format information
-		if err := wavDecoder.FwdToPCM(); err != nil {
-			log.Fatalf("Error reading WAV file header: %v", err)
-		}
-
-		if wavDecoder.BitDepth < 16 {
-			logger.Fatalf("Bit depth too low (%v < 16), cannot encode to QOA format!", wavDecoder.BitDepth)
-		}
-
-		// Attempt to estimate total number of samples
-		bytesPerSample := int(wavDecoder.BitDepth / 8)
-		numSamples := wavDecoder.PCMSize / (int(wavDecoder.NumChans) * bytesPerSample)
-
-		// Preallocate decodedData slice based on the estimation
-		decodedData = make([]int16, numSamples*int(wavDecoder.NumChans))
-
-		// Initialize an audio.IntBuffer to hold the PCM data
-		pcmBuffer := &audio.IntBuffer{Data: make([]int, 4096), Format: wavDecoder.Format()}
-		sampleIndex := 0
-
-		for {
-			n, err := wavDecoder.PCMBuffer(pcmBuffer)
-			if err != nil {
-				log.Fatalf("Error decoding WAV file: %v", err)
-			}
-			if n == 0 {
-				break
-			}
-
-			// Directly copy the decoded PCM data to decodedData slice at the correct position
-			for i := 0; i < n; i++ {
-				decodedData[sampleIndex] = int16(pcmBuffer.Data[i])
-				sampleIndex++
-			}
-		}
-
-		decodedData = decodedData[:sampleIndex]
-
-		q = qoa.NewEncoder(
-			uint32(wavDecoder.Format().SampleRate),
-			uint32(wavDecoder.Format().NumChannels),
-			uint32(numSamples),
-		)
-
-		logger.Debug(
-			inputFile,
-			"channels", pcmBuffer.Format.NumChannels,
-			"samplerate(hz)", pcmBuffer.Format.SampleRate,
-			"samples/channel", numSamples,
-			"bit depth", wavDecoder.SampleBitDepth(),
-			"size", formatSize(len(inputData)),
-			"duration", fmt.Sprintf("%v sec", numSamples/pcmBuffer.Format.SampleRate),
-		)
-		if wavDecoder.SampleBitDepth() > 16 {
-			logger.Warn("Bit depth is greater than 16, this may result in loss of precision and sound quality!")
-		}
-	case ".mp3":
-		decodedData, q = decodeMp3(&inputData, inputFile)
-	case ".ogg":
-		logger.Info("Input format is OGG")
-		oggReader := bytes.NewReader(inputData)
-		oggData, format, err := oggvorbis.ReadAll(oggReader)
-		if err != nil {
-			log.Fatalf("Error decoding OGG data: %v", err)
-		}
-
-		decodedData = make([]int16, len(oggData))
-		for i, val := range oggData {
-			// Scale to int16 range
-			decodedData[i] = int16(val * 32767.0)
-		}
-
-		// Set QOA metadata
-		numSamples := len(decodedData) / format.Channels
-		q = qoa.NewEncoder(
-			uint32(format.SampleRate),
-			uint32(format.Channels),
-			uint32(numSamples),
-		)
-
-		logger.Debug(inputFile, "channels", format.Channels, "samplerate(hz)", format.SampleRate, "samples/channel", numSamples, "size", formatSize(len(inputData)))
-	case ".flac":
-		logger.Info("Input format is FLAC")
-		flacStream, err := flac.Open(inputFile)
-		if err != nil {
-			log.Fatalf("Error opening FLAC file: %v", err)
-		}
-		defer flacStream.Close()
-
-		for {
-			// Decode FLAC frame
-			flacFrame, err := flacStream.ParseNext()
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				log.Fatalf("Error parsing FLAC frame: %v", err)
-			}
-
-			// Collect audio samples
-			for i := 0; i < flacFrame.Subframes[0].NSamples; i++ {
-				for _, subframe := range flacFrame.Subframes {
-					sample := subframe.Samples[i]
-					decodedData = append(decodedData, int16(sample))
-				}
-			}
-		}
-		// Set QOA metadata
-		flacMetadata := flacStream.Info
-		numSamples := len(decodedData) / int(flacMetadata.NChannels)
-		q = qoa.NewEncoder(
-			flacMetadata.SampleRate,
-			uint32(flacMetadata.NChannels),
-			uint32(numSamples),
-		)
+	decodedData, q, err := decodeAudio(inputFile)
+	if err != nil {
+		logger.Fatalf("Error decoding %s: %v", inputFile, err)
+	}
 
-		logger.Debug(
-			inputFile,
-			"channels", flacMetadata.NChannels,
-			"samplerate(hz)", flacMetadata.SampleRate,
-			"samples/channel", numSamples,
-			"bit depth", flacMetadata.BitsPerSample,
-			"size", formatSize(len(inputData)),
-		)
-		if flacMetadata.BitsPerSample > 16 {
-			logger.Warn("Bit depth is greater than 16, this may result in loss of precision and sound quality!")
-		}
+	if err := encodeAudio(outputFile, q, decodedData); err != nil {
+		logger.Fatalf("Error encoding %s: %v", outputFile, err)
 	}
 
-	outExt := filepath.Ext(outputFile)
+	logger.Infof("Conversion completed: %s -> %s", inputFile, outputFile)
+}
+
+// encodeAudio encodes 16-bit PCM data to an audio file, in the format of its file extension.
+func encodeAudio(outputFile string, q *qoa.QOA, decodedData []int16) error {
+	outExt := audioExt(outputFile)
 	switch outExt {
 	case ".qoa":
 		logger.Info("Output format is QOA")
 		// Encode the audio data
-		qoaEncodedData, err := q.Encode(decodedData)
+		qoaEncodedData, err := encodeQOA(q, decodedData)
 		if err != nil {
-			log.Fatalf("Error encoding audio data to QOA: %v", err)
+			return fmt.Errorf("encoding audio data to QOA: %w", err)
 		}
 		// Save the QOA audio data to QOA file
 		qoaFile, err := os.Create(outputFile)
 		if err != nil {
-			log.Fatalf("Error creating QOA file: %v", err)
+			return fmt.Errorf("creating QOA file: %w", err)
 		}
 		defer qoaFile.Close()
 		_, err = qoaFile.Write(qoaEncodedData)
 		if err != nil {
-			log.Fatalf("Error writing QOA data: %v", err)
+			return fmt.Errorf("writing QOA data: %w", err)
 		}
 
 		psnr := -20.0 * math.Log10(math.Sqrt(float64(q.ErrorCount/int(q.Samples*q.Channels)))/32768.0)
@@ -252,52 +138,48 @@ func convertAudio(inputFile, outputFile string) {
 		logger.Debug(outputFile, "size", formatSize(len(qoaEncodedData)), "bitrate", fmt.Sprintf("%0.2f kbit/s", bitrate), "psnr", fmt.Sprintf("%0.2f", psnr))
 	case ".wav":
 		logger.Info("Output format is WAV")
-		// Convert int16 to int for WAV conversion
-		intAudioData := make([]int, len(decodedData))
-		for i, val := range decodedData {
-			intAudioData[i] = int(val)
-		}
-
-		wavBuffer := &audio.IntBuffer{
-			Data:           intAudioData,
-			Format:         &audio.Format{SampleRate: int(q.SampleRate), NumChannels: int(q.Channels)},
-			SourceBitDepth: 16,
-		}
 		// Write the WAV audio data to WAV file
 		wavFile, err := os.Create(outputFile)
 		if err != nil {
-			log.Fatalf("Error creating WAV file: %v", err)
+			return fmt.Errorf("creating WAV file: %w", err)
 		}
 		defer wavFile.Close()
 
-		wavEncoder := wav.NewEncoder(
-			wavFile,
-			int(q.SampleRate),
-			16,
-			int(q.Channels),
-			1)
-		if err = wavEncoder.Write(wavBuffer); err != nil {
-			log.Fatalf("Error writing WAV data: %v", err)
+		if err := encodeWAV(wavFile, q, decodedData); err != nil {
+			return fmt.Errorf("writing WAV data: %w", err)
 		}
-		defer wavEncoder.Close()
 	case ".mp3":
-		encodeMp3(outputFile, q, decodedData)
+		return encodeMp3(outputFile, q, decodedData)
+	case ".pcm", ".raw":
+		logger.Info("Output format is raw PCM", "format", rawPCM.encoding)
+		if outputFile == stdioFilename {
+			return encodeRaw(os.Stdout, decodedData, rawPCM.encoding)
+		}
+		rawFile, err := os.Create(outputFile)
+		if err != nil {
+			return fmt.Errorf("creating raw PCM file: %w", err)
+		}
+		defer rawFile.Close()
+
+		if err := encodeRaw(rawFile, decodedData, rawPCM.encoding); err != nil {
+			return fmt.Errorf("writing raw PCM data: %w", err)
+		}
 	case ".ogg":
 		logger.Info("Encoding to OGG using libvorbis")
 		f, err := os.Create(outputFile)
 		if err != nil {
-			log.Fatalf("Error creating OGG file: %v", err)
+			return fmt.Errorf("creating OGG file: %w", err)
 		}
 		defer f.Close()
 
 		if err := encodeVorbisToOgg(f, decodedData, int(q.SampleRate), int(q.Channels)); err != nil {
-			log.Fatalf("Error encoding OGG: %v", err)
+			return fmt.Errorf("encoding OGG: %w", err)
 		}
 	case ".flac":
 		logger.Info("Output format is FLAC")
 		flacFile, err := os.Create(outputFile)
 		if err != nil {
-			log.Fatalf("Error creating QOA file: %v", err)
+			return fmt.Errorf("creating FLAC file: %w", err)
 		}
 		defer flacFile.Close()
 
@@ -311,7 +193,7 @@ func convertAudio(inputFile, outputFile string) {
 			BlockSizeMax:  4096,
 		})
 		if err != nil {
-			log.Fatalf("Failed to initialize FLAC encoder: %v", err)
+			return fmt.Errorf("initializing FLAC encoder: %w", err)
 		}
 		// Put the audio data into FLAC frames
 		const numSamplesPerChannel = 16
@@ -367,7 +249,7 @@ func convertAudio(inputFile, outputFile string) {
 			// Construct FLAC Frame
 			channels, err := getFLACChannels(numChannels)
 			if err != nil {
-				log.Fatalf("Error getting FLAC channels: %v", err)
+				return fmt.Errorf("getting FLAC channels: %w", err)
 			}
 
 			frameData := &frame.Frame{
@@ -383,17 +265,289 @@ func convertAudio(inputFile, outputFile string) {
 
 			// Write FLAC Frame
 			if err := flacEnc.WriteFrame(frameData); err != nil {
-				log.Fatalf("Error writing FLAC frame: %v", err)
+				return fmt.Errorf("writing FLAC frame: %w", err)
 			}
 
 		}
 
 		if err := flacEnc.Close(); err != nil {
-			log.Fatalf("Error closing FLAC encoder: %v", err)
+			return fmt.Errorf("closing FLAC encoder: %w", err)
 		}
+	default:
+		return fmt.Errorf("unsupported output format %q", outExt)
 	}
 
-	logger.Infof("Conversion completed: %s -> %s", inputFile, outputFile)
+	return nil
+
+}
+
+// decodeAudio reads and decodes an audio file of any supported format.
+func decodeAudio(inputFile string) ([]int16, *qoa.QOA, error) {
+	var inputData []byte
+	var err error
+	if inputFile == stdioFilename {
+		inputData, err = io.ReadAll(os.Stdin)
+	} else {
+		inputData, err = os.ReadFile(inputFile)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading audio file: %w", err)
+	}
+	return decodeAudioData(inputData, audioExt(inputFile), inputFile)
+}
+
+// decodeAudioData decodes audio bytes in the format of the file extension ext to 16-bit PCM.
+// It also returns the QOA description of the audio, ready to encode it.
+func decodeAudioData(inputData []byte, ext string, filename string) (decodedData []int16, q *qoa.QOA, err error) {
+	switch ext {
+	case ".qoa":
+		logger.Info("Input format is QOA")
+		q, decodedData, err = decodeQOA(inputData)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding QOA data: %w", err)
+		}
+	case ".wav":
+		logger.Info("Input format is WAV")
+		decodedData, q, err = decodeWAV(inputData, filename)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding WAV data: %w", err)
+		}
+	case ".mp3":
+		decodedData, q, err = decodeMp3(&inputData, filename)
+		if err != nil {
+			return nil, nil, err
+		}
+	case ".ogg":
+		logger.Info("Input format is OGG")
+		oggData, sampleRate, channels, err := decodeOggVorbis(inputData)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding OGG data: %w", err)
+		}
+
+		decodedData = make([]int16, len(oggData))
+		for i, val := range oggData {
+			// Scale to int16 range
+			decodedData[i] = int16(val * 32767.0)
+		}
+
+		// Set QOA metadata
+		numSamples := len(decodedData) / channels
+		q = qoa.NewEncoder(
+			uint32(sampleRate),
+			uint32(channels),
+			uint32(numSamples),
+		)
+
+		logger.Debug(filename, "channels", channels, "samplerate(hz)", sampleRate, "samples/channel", numSamples, "size", formatSize(len(inputData)))
+	case ".flac":
+		logger.Info("Input format is FLAC")
+		flacStream, err := flac.New(bytes.NewReader(inputData))
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening FLAC file: %w", err)
+		}
+		defer flacStream.Close()
+		if flacStream.Info.NChannels < 1 || flacStream.Info.NChannels > qoa.QOAMaxChannels {
+			return nil, nil, fmt.Errorf("unsupported channel count %d", flacStream.Info.NChannels)
+		}
+
+		for {
+			// Decode FLAC frame
+			flacFrame, err := flacStream.ParseNext()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return nil, nil, fmt.Errorf("parsing FLAC frame: %w", err)
+			}
+
+			if len(flacFrame.Subframes) != int(flacStream.Info.NChannels) {
+				return nil, nil, fmt.Errorf("FLAC frame has %d channels, expected %d", len(flacFrame.Subframes), flacStream.Info.NChannels)
+			}
+			for _, subframe := range flacFrame.Subframes {
+				if len(subframe.Samples) < flacFrame.Subframes[0].NSamples {
+					return nil, nil, errors.New("FLAC subframe is missing samples")
+				}
+			}
+
+			// Collect audio samples
+			for i := 0; i < flacFrame.Subframes[0].NSamples; i++ {
+				for _, subframe := range flacFrame.Subframes {
+					sample := subframe.Samples[i]
+					decodedData = append(decodedData, int16(sample))
+				}
+			}
+		}
+		// Set QOA metadata
+		flacMetadata := flacStream.Info
+		numSamples := len(decodedData) / int(flacMetadata.NChannels)
+		q = qoa.NewEncoder(
+			flacMetadata.SampleRate,
+			uint32(flacMetadata.NChannels),
+			uint32(numSamples),
+		)
+
+		logger.Debug(
+			filename,
+			"channels", flacMetadata.NChannels,
+			"samplerate(hz)", flacMetadata.SampleRate,
+			"samples/channel", numSamples,
+			"bit depth", flacMetadata.BitsPerSample,
+			"size", formatSize(len(inputData)),
+		)
+		if flacMetadata.BitsPerSample > 16 {
+			logger.Warn("Bit depth is greater than 16, this may result in loss of precision and sound quality!")
+		}
+	case ".pcm", ".raw":
+		logger.Info("Input format is raw PCM", "format", rawPCM.encoding)
+		decodedData, q, err = decodeRaw(inputData, rawPCM)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding raw PCM data: %w", err)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported input format %q", ext)
+	}
+
+	return decodedData, q, nil
+}
+
+// decodeWAV decodes WAV file bytes to 16-bit PCM and returns a QOA description for it.
+func decodeWAV(inputData []byte, filename string) ([]int16, *qoa.QOA, error) {
+	if err := checkWAVChunks(inputData); err != nil {
+		return nil, nil, err
+	}
+	wavReader := bytes.NewReader(inputData)
+	wavDecoder := wav.NewDecoder(wavReader)
+
+	// Read the WAV header to get format information
+	if err := wavDecoder.FwdToPCM(); err != nil {
+		return nil, nil, fmt.Errorf("reading WAV file header: %w", err)
+	}
+
+	if wavDecoder.BitDepth < 16 {
+		return nil, nil, fmt.Errorf("bit depth too low (%v < 16), cannot encode to QOA format", wavDecoder.BitDepth)
+	}
+
+	if wavDecoder.NumChans == 0 || wavDecoder.NumChans > qoa.QOAMaxChannels {
+		return nil, nil, fmt.Errorf("unsupported channel count %d", wavDecoder.NumChans)
+	}
+	if wavDecoder.SampleRate == 0 {
+		return nil, nil, errors.New("invalid sample rate 0")
+	}
+
+	// Attempt to estimate total number of samples. The header can claim any size,
+	// so the estimate can't be larger than the file.
+	bytesPerSample := int(wavDecoder.BitDepth / 8)
+	numSamples := min(wavDecoder.PCMSize, len(inputData)) / (int(wavDecoder.NumChans) * bytesPerSample)
+
+	// Preallocate decodedData slice based on the estimation
+	decodedData := make([]int16, 0, numSamples*int(wavDecoder.NumChans))
+
+	// Initialize an audio.IntBuffer to hold the PCM data
+	pcmBuffer := &audio.IntBuffer{Data: make([]int, 4096), Format: wavDecoder.Format()}
+
+	for {
+		n, err := wavDecoder.PCMBuffer(pcmBuffer)
+		if err != nil {
+			return nil, nil, err
+		}
+		if n == 0 {
+			break
+		}
+
+		for i := 0; i < n; i++ {
+			decodedData = append(decodedData, int16(pcmBuffer.Data[i]))
+		}
+	}
+	// Only whole sample frames are audio
+	numSamples = len(decodedData) / int(wavDecoder.NumChans)
+	decodedData = decodedData[:numSamples*int(wavDecoder.NumChans)]
+
+	q := qoa.NewEncoder(
+		uint32(wavDecoder.Format().SampleRate),
+		uint32(wavDecoder.Format().NumChannels),
+		uint32(numSamples),
+	)
+
+	logger.Debug(
+		filename,
+		"channels", pcmBuffer.Format.NumChannels,
+		"samplerate(hz)", pcmBuffer.Format.SampleRate,
+		"samples/channel", numSamples,
+		"bit depth", wavDecoder.SampleBitDepth(),
+		"size", formatSize(len(inputData)),
+		"duration", fmt.Sprintf("%v sec", numSamples/pcmBuffer.Format.SampleRate),
+	)
+	if wavDecoder.SampleBitDepth() > 16 {
+		logger.Warn("Bit depth is greater than 16, this may result in loss of precision and sound quality!")
+	}
+
+	return decodedData, q, nil
+}
+
+// encodeWAV writes 16-bit PCM data as a WAV file to w.
+func encodeWAV(w io.WriteSeeker, q *qoa.QOA, decodedData []int16) error {
+	// Convert int16 to int for WAV conversion
+	intAudioData := make([]int, len(decodedData))
+	for i, val := range decodedData {
+		intAudioData[i] = int(val)
+	}
+
+	wavBuffer := &audio.IntBuffer{
+		Data:           intAudioData,
+		Format:         &audio.Format{SampleRate: int(q.SampleRate), NumChannels: int(q.Channels)},
+		SourceBitDepth: 16,
+	}
+
+	wavEncoder := wav.NewEncoder(
+		w,
+		int(q.SampleRate),
+		16,
+		int(q.Channels),
+		1)
+	if err := wavEncoder.Write(wavBuffer); err != nil {
+		return err
+	}
+	return wavEncoder.Close()
+}
+
+// memWriteSeeker is an in-memory io.WriteSeeker, for encoders that need to seek back
+// and patch headers.
+type memWriteSeeker struct {
+	buf []byte
+	pos int
+}
+
+func (m *memWriteSeeker) Write(p []byte) (int, error) {
+	if end := m.pos + len(p); end > len(m.buf) {
+		m.buf = append(m.buf, make([]byte, end-len(m.buf))...)
+	}
+	n := copy(m.buf[m.pos:], p)
+	m.pos += n
+	return n, nil
+}
+
+func (m *memWriteSeeker) Seek(offset int64, whence int) (int64, error) {
+	var newPos int64
+	switch whence {
+	case io.SeekStart:
+		newPos = offset
+	case io.SeekCurrent:
+		newPos = int64(m.pos) + offset
+	case io.SeekEnd:
+		newPos = int64(len(m.buf)) + offset
+	default:
+		return 0, errors.New("memWriteSeeker: invalid whence")
+	}
+	if newPos < 0 {
+		return 0, errors.New("memWriteSeeker: negative position")
+	}
+	m.pos = int(newPos)
+	return newPos, nil
+}
+
+// Bytes returns everything written so far.
+func (m *memWriteSeeker) Bytes() []byte {
+	return m.buf
 }
 
 func getFLACChannels(numChannels int) (frame.Channels, error) {
@@ -432,3 +586,50 @@ func formatSize(inputSize int) string {
 	}
 	return fmt.Sprintf("%.2f %cB", float64(inputSize)/float64(div), "KMGTPE"[exp])
 }
+
+// checkWAVChunks checks that no chunk in WAV file bytes claims to be larger than the file.
+// The WAV decoder allocates metadata chunks at the size they claim before reading them.
+// The data chunk isn't allocated, and is allowed to run past the end, as it does in
+// files written by streaming encoders that never went back to fix the header.
+func checkWAVChunks(data []byte) error {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return errors.New("not a RIFF WAVE file")
+	}
+	for p := 12; p+8 <= len(data); {
+		id := string(data[p : p+4])
+		size := int(binary.LittleEndian.Uint32(data[p+4:]))
+		p += 8
+		if id == "data" {
+			return nil
+		}
+		if size > len(data)-p {
+			return fmt.Errorf("%q chunk claims %d bytes, but only %d remain", id, size, len(data)-p)
+		}
+		if id == "LIST" {
+			if err := checkWAVListChunk(data[p : p+size]); err != nil {
+				return err
+			}
+		}
+		// Chunks are padded to an even size
+		p += size + size%2
+	}
+	return nil
+}
+
+// checkWAVListChunk checks the sizes of the entries of a LIST INFO chunk.
+func checkWAVListChunk(list []byte) error {
+	if len(list) < 4 || string(list[:4]) != "INFO" {
+		// The decoder skips other lists
+		return nil
+	}
+	for p := 4; p+8 <= len(list); {
+		id := string(list[p : p+4])
+		size := int(binary.LittleEndian.Uint32(list[p+4:]))
+		p += 8
+		if size > len(list)-p {
+			return fmt.Errorf("%q LIST entry claims %d bytes, but only %d remain", id, size, len(list)-p)
+		}
+		p += size
+	}
+	return nil
+}
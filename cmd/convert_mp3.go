@@ -3,8 +3,8 @@ package cmd
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
-	"log"
 	"os"
 
 	"github.com/braheezy/qoa"
@@ -12,7 +12,7 @@ import (
 	"github.com/hajimehoshi/ebiten/v2/audio/mp3"
 )
 
-func decodeMp3(inputData *[]byte, filename string) ([]int16, *qoa.QOA) {
+func decodeMp3(inputData *[]byte, filename string) ([]int16, *qoa.QOA, error) {
 	logger.Info("Input format is MP3")
 
 	// Create a reader from the input data
@@ -21,7 +21,7 @@ func decodeMp3(inputData *[]byte, filename string) ([]int16, *qoa.QOA) {
 	// Decode the MP3 data using ebiten's mp3 decoder
 	stream, err := mp3.DecodeWithoutResampling(reader)
 	if err != nil {
-		log.Fatalf("Error decoding MP3 data: %v", err)
+		return nil, nil, fmt.Errorf("decoding MP3 data: %w", err)
 	}
 
 	// Get audio properties
@@ -37,7 +37,7 @@ func decodeMp3(inputData *[]byte, filename string) ([]int16, *qoa.QOA) {
 			break
 		}
 		if err != nil {
-			log.Fatalf("Error reading MP3 stream: %v", err)
+			return nil, nil, fmt.Errorf("reading MP3 stream: %w", err)
 		}
 		audioData = append(audioData, buf[:n]...)
 	}
@@ -51,6 +51,7 @@ func decodeMp3(inputData *[]byte, filename string) ([]int16, *qoa.QOA) {
 
 	// Set QOA metadata
 	numSamples := len(decodedData) / channels
+	decodedData = decodedData[:numSamples*channels]
 	q := qoa.NewEncoder(
 		uint32(sampleRate),
 		uint32(channels),
@@ -58,18 +59,26 @@ func decodeMp3(inputData *[]byte, filename string) ([]int16, *qoa.QOA) {
 	)
 
 	logger.Debug(filename, "channels", channels, "samplerate(hz)", sampleRate, "samples/channel", numSamples, "size", formatSize(len(*inputData)))
-	return decodedData, q
+	return decodedData, q, nil
 }
 
-func encodeMp3(outputFile string, q *qoa.QOA, decodedData []int16) {
+func encodeMp3(outputFile string, q *qoa.QOA, decodedData []int16) error {
 	logger.Info("Output format is MP3")
 
 	mp3File, err := os.Create(outputFile)
 	if err != nil {
-		log.Fatalf("Error creating MP3 file: %v", err)
+		return fmt.Errorf("creating MP3 file: %w", err)
 	}
 	defer mp3File.Close()
-	mp3Encoder := mp3encoder.NewEncoder(int(q.SampleRate), int(q.Channels))
 
-	mp3Encoder.Write(mp3File, decodedData)
+	if err := writeMp3(mp3File, q, decodedData); err != nil {
+		return fmt.Errorf("writing MP3 data: %w", err)
+	}
+	return nil
+}
+
+// writeMp3 encodes 16-bit PCM data as MP3 to w.
+func writeMp3(w io.Writer, q *qoa.QOA, decodedData []int16) error {
+	mp3Encoder := mp3encoder.NewEncoder(int(q.SampleRate), int(q.Channels))
+	return mp3Encoder.Write(w, decodedData)
 }
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/braheezy/qoa"
+	"github.com/jfreymuth/vorbis"
+	"github.com/jonas747/ogg"
+)
+
+// decodeOggVorbis decodes the first Vorbis stream of Ogg data to interleaved samples from -1
+// to 1. Pages are put together into packets here, rather than with oggvorbis, so the granule
+// positions that trim the start and end of the stream can be checked before they're used.
+func decodeOggVorbis(data []byte) (samples []float32, sampleRate, channels int, err error) {
+	pages := ogg.NewDecoder(bytes.NewReader(data))
+	var dec vorbis.Decoder
+	var partial []byte
+	var out []float32
+	headers := 0
+	serial, first := uint32(0), true
+	// firstGranule and firstFrames are the granule position of the first page to finish an
+	// audio packet and the frames decoded by then. lastGranule is that of the last page, and
+	// lastFrames the frames decoded before it.
+	firstGranule, firstFrames := int64(-1), 0
+	lastGranule, lastFrames := int64(-1), 0
+
+	for {
+		page, err := pages.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("reading Ogg page: %w", err)
+		}
+		if first {
+			serial, first = page.Serial, false
+		}
+		if page.Serial != serial {
+			// Other streams, like a video, are skipped
+			continue
+		}
+		if page.Type&ogg.COP == 0 {
+			partial = partial[:0]
+		}
+		before := len(samples)
+
+		for {
+			packet, complete, err := page.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			partial = append(partial, packet...)
+			if !complete {
+				break
+			}
+			packet, partial = partial, nil
+
+			if headers < 3 {
+				if err := dec.ReadHeader(packet); err != nil {
+					return nil, 0, 0, err
+				}
+				headers++
+				if headers == 1 && (dec.Channels() < 1 || dec.Channels() > qoa.QOAMaxChannels) {
+					return nil, 0, 0, fmt.Errorf("unsupported channel count %d", dec.Channels())
+				}
+				continue
+			}
+			if !dec.HeadersRead() {
+				return nil, 0, 0, errors.New("vorbis: missing headers")
+			}
+			if out == nil {
+				out = make([]float32, dec.BufferSize())
+			}
+			decoded, err := dec.DecodeInto(packet, out)
+			if err != nil {
+				return nil, 0, 0, fmt.Errorf("decoding Vorbis packet: %w", err)
+			}
+			samples = append(samples, decoded...)
+		}
+
+		if headers == 3 && page.Granule >= 0 {
+			if firstGranule < 0 && len(samples) > 0 {
+				firstGranule, firstFrames = page.Granule, len(samples)/dec.Channels()
+			}
+			lastGranule = page.Granule
+			lastFrames = before / dec.Channels()
+		}
+		if page.Type&ogg.EOS != 0 {
+			break
+		}
+	}
+	if !dec.HeadersRead() {
+		return nil, 0, 0, errors.New("vorbis: missing headers")
+	}
+
+	// The granule positions say where the audio starts and ends. The end may only be trimmed
+	// within the last page, so granule positions that would trim more are ignored.
+	channels = dec.Channels()
+	frames := len(samples) / channels
+	start := 0
+	if firstGranule >= 0 && int64(firstFrames) > firstGranule {
+		start = firstFrames - int(firstGranule)
+	}
+	end := frames
+	if lastGranule >= 0 && lastGranule+int64(start) < int64(end) && lastGranule+int64(start) >= int64(lastFrames) {
+		end = int(lastGranule) + start
+	}
+	samples = samples[start*channels : end*channels]
+	for i, v := range samples {
+		samples[i] = max(-1, min(v, 1))
+	}
+	return samples, dec.SampleRate(), channels, nil
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// fft computes the discrete Fourier transform of x in place. len(x) must be a power of 2.
+func fft(x []complex128) {
+	n := len(x)
+
+	// Bit reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	// Iterative radix-2 butterflies
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a := x[start+k]
+				b := x[start+k+size/2] * w
+				x[start+k] = a + b
+				x[start+k+size/2] = a - b
+				w *= step
+			}
+		}
+	}
+}
+
+// windowFunctions are the supported FFT window shapes.
+var windowFunctions = map[string]func(i, n int) float64{
+	"rect": func(i, n int) float64 { return 1 },
+	"hann": func(i, n int) float64 {
+		return 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+	},
+	"hamming": func(i, n int) float64 {
+		return 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+	},
+	"blackman": func(i, n int) float64 {
+		a := 2 * math.Pi * float64(i) / float64(n-1)
+		return 0.42 - 0.5*math.Cos(a) + 0.08*math.Cos(2*a)
+	},
+}
+
+// makeWindow returns the coefficients of the named window with n points.
+func makeWindow(name string, n int) ([]float64, error) {
+	f, ok := windowFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown window %q", name)
+	}
+	window := make([]float64, n)
+	for i := range window {
+		window[i] = f(i, n)
+	}
+	return window, nil
+}
+
+// spectrumAnalyzer computes magnitude spectra of fixed size blocks of samples.
+type spectrumAnalyzer struct {
+	window []float64
+	// windowGain is the sum of the window, used to scale a full scale sine to 0 dB.
+	windowGain float64
+	buf        []complex128
+}
+
+func newSpectrumAnalyzer(size int, windowName string) (*spectrumAnalyzer, error) {
+	if size < 2 || size&(size-1) != 0 {
+		return nil, fmt.Errorf("FFT size %d is not a power of 2", size)
+	}
+	window, err := makeWindow(windowName, size)
+	if err != nil {
+		return nil, err
+	}
+	gain := 0.0
+	for _, w := range window {
+		gain += w
+	}
+	return &spectrumAnalyzer{window: window, windowGain: gain, buf: make([]complex128, size)}, nil
+}
+
+// magnitudesDB returns the level of the size/2 frequency bins in dBFS. samples are
+// normalized to [-1, 1] and zero-padded if shorter than the FFT size.
+func (s *spectrumAnalyzer) magnitudesDB(samples []float64, out []float64) []float64 {
+	for i := range s.buf {
+		v := 0.0
+		if i < len(samples) {
+			v = samples[i] * s.window[i]
+		}
+		s.buf[i] = complex(v, 0)
+	}
+	fft(s.buf)
+
+	bins := len(s.buf) / 2
+	if cap(out) < bins {
+		out = make([]float64, bins)
+	}
+	out = out[:bins]
+	for i := range out {
+		magnitude := 2 * cmplx.Abs(s.buf[i]) / s.windowGain
+		out[i] = 20 * math.Log10(math.Max(magnitude, 1e-10))
+	}
+	return out
+}
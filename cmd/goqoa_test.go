@@ -1,15 +1,28 @@
 package cmd
 
 import (
+	"archive/zip"
 	"bytes"
 	"crypto/md5"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/braheezy/qoa"
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/require"
 )
@@ -117,3 +130,1346 @@ func TestConvertCmd(t *testing.T) {
 		os.Remove(outputFilename)
 	}
 }
+
+func TestSpecCheckCmd(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Build a miniature sample pack from our own reference outputs.
+	refWav := filepath.Join(tempDir, "test.qoa.wav")
+	_, err := execute(t, rootCmd, "convert", "testdata/wav/test.wav.qoa", refWav)
+	require.NoError(t, err)
+
+	packFile := filepath.Join(tempDir, "pack.zip")
+	f, err := os.Create(packFile)
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for name, src := range map[string]string{
+		"pack/wav/test.wav":         "testdata/wav/test.wav",
+		"pack/qoa/test.qoa":         "testdata/wav/test.wav.qoa",
+		"pack/qoa_wav/test.qoa.wav": refWav,
+	} {
+		data, err := os.ReadFile(src)
+		require.NoError(t, err)
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	failuresFile := filepath.Join(tempDir, "failures")
+	_, err = execute(t, rootCmd, "spec-check", "-a", "--failures", failuresFile, packFile)
+	require.NoError(t, err)
+
+	failures, err := os.ReadFile(failuresFile)
+	require.NoError(t, err)
+	require.Empty(t, strings.TrimSpace(string(failures)))
+
+	// A reference that differs deep into the file only fails a whole file comparison
+	refQOA, err := os.ReadFile("testdata/wav/test.wav.qoa")
+	require.NoError(t, err)
+	refQOA[len(refQOA)-1] ^= 0xff
+	pack := &samplePack{files: map[string]func() ([]byte, error){
+		"test.wav": func() ([]byte, error) { return os.ReadFile("testdata/wav/test.wav") },
+		"test.qoa": func() ([]byte, error) { return refQOA, nil },
+	}}
+	samples := pack.samples()
+	require.Len(t, samples, 1)
+	mismatches, err := checkSample(pack, samples[0], 0)
+	require.NoError(t, err)
+	require.Len(t, mismatches, 1)
+	require.Contains(t, mismatches[0], fmt.Sprintf("at byte %d", len(refQOA)-1))
+	mismatches, err = checkSample(pack, samples[0], 500)
+	require.NoError(t, err)
+	require.Empty(t, mismatches)
+}
+
+func TestCatCmd(t *testing.T) {
+	outputFilename := filepath.Join(t.TempDir(), "joined.qoa")
+	inputFilename := "testdata/wav/test.wav.qoa"
+
+	_, err := execute(t, rootCmd, "cat", "--partial", "pad", inputFilename, inputFilename, "-o", outputFilename)
+	require.NoError(t, err)
+
+	inputData, err := os.ReadFile(inputFilename)
+	require.NoError(t, err)
+	inputQOA, inputFrames, err := parseQOAFrames(inputData)
+	require.NoError(t, err)
+	outputData, err := os.ReadFile(outputFilename)
+	require.NoError(t, err)
+	outputQOA, outputFrames, err := parseQOAFrames(outputData)
+	require.NoError(t, err)
+
+	// The partial frame at the end of the first input is padded to a full frame.
+	require.Equal(t, 2*len(inputFrames), len(outputFrames))
+	require.Equal(t, uint32(qoa.QOAFrameLen*len(inputFrames))+inputQOA.Samples, outputQOA.Samples)
+	for i, f := range inputFrames {
+		if f.full() {
+			require.Equal(t, f.data, outputFrames[i].data, "frame %d was not copied verbatim", i)
+		}
+		require.Equal(t, f.data, outputFrames[len(inputFrames)+i].data, "frame %d was not copied verbatim", len(inputFrames)+i)
+	}
+}
+
+func TestSplitCmd(t *testing.T) {
+	tempDir := t.TempDir()
+	inputFilename := "testdata/wav/test.wav.qoa"
+
+	_, err := execute(t, rootCmd, "split", "--frames", "10", "-o", filepath.Join(tempDir, "{name}-{index}.qoa"), inputFilename)
+	require.NoError(t, err)
+
+	inputData, err := os.ReadFile(inputFilename)
+	require.NoError(t, err)
+	inputQOA, inputFrames, err := parseQOAFrames(inputData)
+	require.NoError(t, err)
+
+	// Cuts on frame boundaries copy every frame verbatim.
+	var joined []qoaFrame
+	totalSamples := uint32(0)
+	for i := 1; i <= (len(inputFrames)+9)/10; i++ {
+		pieceData, err := os.ReadFile(filepath.Join(tempDir, fmt.Sprintf("test.wav-%03d.qoa", i)))
+		require.NoError(t, err)
+		pieceQOA, pieceFrames, err := parseQOAFrames(pieceData)
+		require.NoError(t, err)
+		totalSamples += pieceQOA.Samples
+		joined = append(joined, pieceFrames...)
+	}
+	require.Equal(t, inputQOA.Samples, totalSamples)
+	require.Equal(t, inputFrames, joined)
+
+	// Exact cuts inside frames leave every frame of a piece full but the last
+	for _, name := range []string{"frames", "every", "exact"} {
+		flag := splitCmd.Flags().Lookup(name)
+		value := flag.Value.String()
+		flag.Changed = false
+		t.Cleanup(func() {
+			flag.Value.Set(value)
+			flag.Changed = false
+		})
+	}
+	_, err = execute(t, rootCmd, "split", "--every", "1s", "--exact", "-o", filepath.Join(tempDir, "exact-{index}.qoa"), inputFilename)
+	require.NoError(t, err)
+	pieces, err := filepath.Glob(filepath.Join(tempDir, "exact-*.qoa"))
+	require.NoError(t, err)
+	require.Greater(t, len(pieces), 1)
+	totalSamples = 0
+	for i, piece := range pieces {
+		pieceData, err := os.ReadFile(piece)
+		require.NoError(t, err)
+		pieceQOA, pieceFrames, err := parseQOAFrames(pieceData)
+		require.NoError(t, err)
+		for j, f := range pieceFrames[:len(pieceFrames)-1] {
+			require.True(t, f.full(), "%s frame %d has %d samples", piece, j, f.samples)
+		}
+		if i < len(pieces)-1 {
+			require.Equal(t, inputQOA.SampleRate, pieceQOA.Samples)
+		}
+		totalSamples += pieceQOA.Samples
+	}
+	require.Equal(t, inputQOA.Samples, totalSamples)
+}
+
+func TestRenderCmd(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for _, kind := range []string{"spectrogram", "waveform"} {
+		outputFilename := filepath.Join(tempDir, kind+".png")
+		_, err := execute(t, rootCmd, "render", kind, "--width", "320", "--height", "120", "-o", outputFilename, "testdata/wav/test.qoa")
+		require.NoError(t, err)
+
+		f, err := os.Open(outputFilename)
+		require.NoError(t, err)
+		config, err := png.DecodeConfig(f)
+		f.Close()
+		require.NoError(t, err)
+		require.Equal(t, 320, config.Width)
+		require.Equal(t, 120, config.Height)
+	}
+
+	// The coding noise of a full scale sine is there, but far quieter, and --diff renders it
+	samples, q, err := generateSignal("sine", signalOptions{SampleRate: 48000, Channels: 1, Duration: time.Second, Frequency: 1000})
+	require.NoError(t, err)
+	noise, err := qoaCodingNoise(samples, q)
+	require.NoError(t, err)
+	require.Len(t, noise, len(samples))
+	require.NotEqual(t, make([]int16, len(noise)), noise)
+	for _, v := range noise {
+		require.Less(t, math.Abs(float64(v)), float64(math.MaxInt16)/10)
+	}
+
+	sourceFilename := filepath.Join(tempDir, "sine.wav")
+	require.NoError(t, encodeAudio(sourceFilename, q, samples))
+	diff := renderSpectrogramCmd.Flags().Lookup("diff")
+	t.Cleanup(func() {
+		diff.Value.Set("false")
+		diff.Changed = false
+	})
+	renderPNG := func(args ...string) image.Image {
+		outputFilename := filepath.Join(tempDir, "sine.png")
+		args = append([]string{"render", "spectrogram", "--width", "320", "--height", "120", "-o", outputFilename}, args...)
+		_, err := execute(t, rootCmd, append(args, sourceFilename)...)
+		require.NoError(t, err)
+		f, err := os.Open(outputFilename)
+		require.NoError(t, err)
+		defer f.Close()
+		img, err := png.Decode(f)
+		require.NoError(t, err)
+		return img
+	}
+	plain := renderPNG()
+	noiseImage := renderPNG("--diff")
+	require.Equal(t, image.Rect(0, 0, 320, 120), noiseImage.Bounds())
+	require.NotEqual(t, plain, noiseImage)
+}
+
+func TestPeaksCmd(t *testing.T) {
+	outputFilename := filepath.Join(t.TempDir(), "peaks.json")
+	_, err := execute(t, rootCmd, "peaks", "--pixels-per-second", "10", "-o", outputFilename, "testdata/wav/test.qoa")
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(outputFilename)
+	require.NoError(t, err)
+	var result struct {
+		Version         int     `json:"version"`
+		Channels        int     `json:"channels"`
+		SampleRate      int     `json:"sample_rate"`
+		SamplesPerPixel int     `json:"samples_per_pixel"`
+		Length          int     `json:"length"`
+		Data            []int16 `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(data, &result))
+
+	require.Equal(t, 2, result.Version)
+	require.Equal(t, 2, result.Channels)
+	require.Equal(t, result.SampleRate/10, result.SamplesPerPixel)
+	require.Len(t, result.Data, result.Length*result.Channels*2)
+	for i := 0; i < len(result.Data); i += 2 {
+		require.LessOrEqual(t, result.Data[i], result.Data[i+1])
+	}
+}
+
+func TestAnalyzeCmd(t *testing.T) {
+	output, err := execute(t, rootCmd, "analyze", "--source", "testdata/wav/test.wav", "testdata/wav/test.wav.qoa")
+	require.NoError(t, err)
+	require.Contains(t, output, "psnr")
+	require.Contains(t, output, "scale factors")
+
+	source, q, err := decodeAudio("testdata/wav/test.wav")
+	require.NoError(t, err)
+	encoded, err := q.Encode(source)
+	require.NoError(t, err)
+
+	// A source of another format can't be compared
+	_, err = analyzeQOA(encoded, source, qoa.NewEncoder(q.SampleRate/2, q.Channels, q.Samples*2), 4)
+	require.ErrorContains(t, err, "Hz")
+	_, err = analyzeQOA(encoded, source, qoa.NewEncoder(q.SampleRate, 1, q.Samples*2), 4)
+	require.ErrorContains(t, err, "channels")
+
+	a, err := analyzeQOA(encoded, source, q, 4)
+	require.NoError(t, err)
+	require.Len(t, a.Frames, int((q.Samples+qoa.QOAFrameLen-1)/qoa.QOAFrameLen))
+	sliceCount := 0
+	for _, n := range a.ScaleFactors {
+		sliceCount += n
+	}
+	require.Equal(t, len(a.Frames[0].Slices)*(len(a.Frames)-1)+len(a.Frames[len(a.Frames)-1].Slices), sliceCount)
+	require.Greater(t, a.PSNR, 40.0)
+	for _, f := range a.Frames {
+		require.NotContains(t, f.Flags, "spike")
+	}
+
+	// Pretend the source was different in one frame, which shows up as an error spike there
+	changed := append([]int16(nil), source...)
+	for i := 10 * qoa.QOAFrameLen * 2; i < 11*qoa.QOAFrameLen*2; i++ {
+		changed[i] = clampInt16(int(changed[i]) + 2000)
+	}
+	a, err = analyzeQOA(encoded, changed, q, 4)
+	require.NoError(t, err)
+	for _, f := range a.Frames {
+		if f.Index == 10 {
+			require.Contains(t, f.Flags, "spike")
+		} else {
+			require.NotContains(t, f.Flags, "spike")
+		}
+	}
+
+	// A file compared against its own decode has no error, which JSON can still hold
+	decoded, q, err := decodeAudio("testdata/wav/test.wav.qoa")
+	require.NoError(t, err)
+	decodedFilename := filepath.Join(t.TempDir(), "decoded.wav")
+	require.NoError(t, encodeAudio(decodedFilename, q, decoded))
+	format := analyzeCmd.Flags().Lookup("format")
+	t.Cleanup(func() {
+		format.Value.Set("table")
+		format.Changed = false
+	})
+	output, err = execute(t, rootCmd, "analyze", "--source", decodedFilename, "--format", "json", "testdata/wav/test.wav.qoa")
+	require.NoError(t, err)
+	a = &qoaAnalysis{}
+	require.NoError(t, json.Unmarshal([]byte(output), a))
+	require.True(t, a.HasSource)
+	require.Greater(t, a.PSNR, 150.0)
+	for _, f := range a.Frames {
+		require.Zero(t, f.MaxError)
+		require.Equal(t, a.PSNR, f.PSNR)
+	}
+}
+
+func TestGenCmd(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range signalNames() {
+		outputFilename := filepath.Join(dir, name+".qoa")
+		_, err := execute(t, rootCmd, "gen", "--duration", "500ms", "--rate", "22050", "--channels", "1", name, outputFilename)
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(outputFilename)
+		require.NoError(t, err)
+		q, _, err := qoa.Decode(data)
+		require.NoError(t, err)
+		require.Equal(t, uint32(22050), q.SampleRate)
+		require.Equal(t, uint32(1), q.Channels)
+		require.Equal(t, uint32(22050/2), q.Samples)
+	}
+
+	// Generated signals round trip through the encoders like the testdata files
+	samples, q, err := generateSignal("sweep", signalOptions{SampleRate: 48000, Duration: time.Second})
+	require.NoError(t, err)
+	require.Len(t, samples, 48000*2)
+	for _, v := range samples {
+		require.LessOrEqual(t, v, int16(32767/2+1))
+	}
+	outputFilename := filepath.Join(dir, "sweep.wav")
+	require.NoError(t, encodeAudio(outputFilename, q, samples))
+	decoded, _, err := decodeAudio(outputFilename)
+	require.NoError(t, err)
+	require.Equal(t, samples, decoded)
+
+	// Noise is reproducible, and differs between channels
+	noise, _, err := generateSignal("pink", signalOptions{Duration: 100 * time.Millisecond, Seed: 7})
+	require.NoError(t, err)
+	again, _, err := generateSignal("pink", signalOptions{Duration: 100 * time.Millisecond, Seed: 7})
+	require.NoError(t, err)
+	require.Equal(t, noise, again)
+	require.NotEqual(t, noise[0], noise[1])
+
+	_, _, err = generateSignal("hum", signalOptions{})
+	require.Error(t, err)
+
+	// Zeros given on the command line are kept, not replaced by the defaults
+	amplitude := genCmd.Flags().Lookup("amplitude")
+	t.Cleanup(func() {
+		amplitude.Value.Set(fmt.Sprint(defaultSignalOptions.Amplitude))
+		amplitude.Changed = false
+	})
+	outputFilename = filepath.Join(dir, "silent.wav")
+	_, err = execute(t, rootCmd, "gen", "--amplitude", "0", "sine", outputFilename)
+	require.NoError(t, err)
+	decoded, q, err = decodeAudio(outputFilename)
+	require.NoError(t, err)
+	require.Equal(t, uint32(22050/2), q.Samples)
+	require.Equal(t, make([]int16, len(decoded)), decoded)
+	o := defaultSignalOptions
+	o.Duration = 0
+	samples, _, err = synthesizeSignal("sine", o)
+	require.NoError(t, err)
+	require.Empty(t, samples)
+}
+
+func TestRawPCM(t *testing.T) {
+	// The flags below set rawPCM, which later conversions read
+	oldRawPCM := rawPCM
+	t.Cleanup(func() {
+		rawPCM = oldRawPCM
+		for _, name := range []string{"rate", "channels", "format"} {
+			convertCmd.Flags().Lookup(name).Changed = false
+		}
+	})
+	dir := t.TempDir()
+	samples, _, err := decodeAudio("testdata/wav/test.qoa")
+	require.NoError(t, err)
+
+	// QOA to raw matches the decoded samples
+	rawFilename := filepath.Join(dir, "test.raw")
+	_, err = execute(t, rootCmd, "convert", "--format", "s16be", "testdata/wav/test.qoa", rawFilename)
+	require.NoError(t, err)
+	data, err := os.ReadFile(rawFilename)
+	require.NoError(t, err)
+	require.Len(t, data, len(samples)*2)
+	require.Equal(t, samples[1], int16(data[2])<<8|int16(data[3]))
+
+	// And back to QOA from stdin, described by the flags
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	os.Stdin, err = os.Open(rawFilename)
+	require.NoError(t, err)
+	defer os.Stdin.Close()
+	qoaFilename := filepath.Join(dir, "test.qoa")
+	_, err = execute(t, rootCmd, "convert", "--rate", "32000", "--channels", "1", "--format", "s16be", "-", qoaFilename)
+	require.NoError(t, err)
+	decoded, q, err := decodeAudio(qoaFilename)
+	require.NoError(t, err)
+	require.Equal(t, uint32(32000), q.SampleRate)
+	require.Equal(t, uint32(1), q.Channels)
+	require.Len(t, decoded, len(samples))
+
+	// Every sample format round trips, within its precision
+	for encoding := range rawEncodings {
+		var buf bytes.Buffer
+		require.NoError(t, encodeRaw(&buf, samples, encoding))
+		require.Equal(t, len(samples)*rawEncodings[encoding], buf.Len())
+
+		roundTrip, q, err := decodeRaw(buf.Bytes(), rawPCMFormat{sampleRate: 48000, channels: 2, encoding: encoding})
+		require.NoError(t, err)
+		require.Equal(t, uint32(len(samples)/2), q.Samples)
+		if encoding == "u8" {
+			for i := range samples {
+				require.Equal(t, samples[i]>>8, roundTrip[i]>>8)
+			}
+		} else {
+			require.Equal(t, samples, roundTrip, encoding)
+		}
+	}
+
+	_, _, err = decodeRaw(nil, rawPCMFormat{sampleRate: 48000, channels: 2, encoding: "s24le"})
+	require.Error(t, err)
+}
+
+func TestMalformedInput(t *testing.T) {
+	setupLogger()
+	wavData, err := os.ReadFile("testdata/wav/test.wav")
+	require.NoError(t, err)
+	qoaData, err := os.ReadFile("testdata/wav/test.qoa")
+	require.NoError(t, err)
+
+	// A data chunk claiming 4 GB decodes what's there
+	data := append([]byte(nil), wavData[:4096]...)
+	i := bytes.Index(data, []byte("data"))
+	binary.LittleEndian.PutUint32(data[i+4:], 0xfffffff0)
+	decoded, q, err := decodeAudioData(data, ".wav", "truncated.wav")
+	require.NoError(t, err)
+	require.Less(t, len(decoded), 4096)
+	require.Equal(t, int(q.Samples*q.Channels), len(decoded))
+
+	// Other chunks are read into memory, so they can't claim more than the file has
+	data = append([]byte(nil), wavData[:4096]...)
+	i = bytes.Index(data, []byte("fmt "))
+	binary.LittleEndian.PutUint32(data[i+4:], 0xff000000)
+	_, _, err = decodeAudioData(data, ".wav", "bad.wav")
+	require.ErrorContains(t, err, "chunk claims")
+
+	// A QOA header claiming 4G samples only decodes the frames present
+	data = append([]byte(nil), qoaData...)
+	binary.BigEndian.PutUint32(data[4:], 0xffffffff)
+	decoded, q, err = decodeAudioData(data, ".qoa", "long.qoa")
+	require.NoError(t, err)
+	_, expected, err := qoa.Decode(qoaData)
+	require.NoError(t, err)
+	require.Equal(t, expected, decoded)
+	require.Equal(t, uint32(len(expected)/2), q.Samples)
+
+	// Too many channels
+	data = append([]byte(nil), qoaData...)
+	data[8] = 200
+	_, _, err = decodeAudioData(data, ".qoa", "channels.qoa")
+	require.Error(t, err)
+
+	// Describing more samples than there are is an error, not a panic
+	_, err = encodeQOA(qoa.NewEncoder(44100, 2, 1000), make([]int16, 10))
+	require.Error(t, err)
+
+	// An Ogg end granule that would trim more than the last page is ignored. This file's
+	// last page claims position 0, after pages up to 144000.
+	oggData, err := os.ReadFile("testdata/ogg/test.qoa.ogg")
+	require.NoError(t, err)
+	decoded, q, err = decodeAudioData(oggData, ".ogg", "test.qoa.ogg")
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, q.Samples, uint32(144000))
+	require.Equal(t, int(q.Samples*q.Channels), len(decoded))
+
+	// A page with no segments is an error
+	_, _, err = decodeAudioData([]byte("OggS\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"), ".ogg", "empty.ogg")
+	require.Error(t, err)
+}
+
+func TestServeHandler(t *testing.T) {
+	setupLogger()
+	server := httptest.NewServer(newLibraryHandler("testdata"))
+	defer server.Close()
+
+	// Raw files support range requests
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/files/wav/test.qoa", nil)
+	require.NoError(t, err)
+	req.Header.Set("Range", "bytes=0-3")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, "qoaf", string(body))
+
+	// Transcoding to WAV matches convert
+	resp, err = http.Get(server.URL + "/files/wav/test.qoa?format=wav")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	expected, err := os.ReadFile("testdata/wav/test.qoa.wav")
+	require.NoError(t, err)
+	require.Equal(t, "audio/wav", resp.Header.Get("Content-Type"))
+	require.Equal(t, expected, body)
+
+	// Seeking asks for ranges of the transcoded file
+	req, err = http.NewRequest(http.MethodGet, server.URL+"/files/wav/test.qoa?format=wav", nil)
+	require.NoError(t, err)
+	req.Header.Set("Range", "bytes=1000-1999")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, expected[1000:2000], body)
+
+	// Transcodes are kept until the file changes
+	filename := filepath.Join(t.TempDir(), "test.qoa")
+	qoaData, err := os.ReadFile("testdata/wav/test.qoa")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filename, qoaData, 0o644))
+	cache := newTranscodeCache()
+	transcoded := func() []byte {
+		stat, err := os.Stat(filename)
+		require.NoError(t, err)
+		data, contentType, err := cache.get(filename, "wav", stat)
+		require.NoError(t, err)
+		require.Equal(t, "audio/wav", contentType)
+		return data
+	}
+	first := transcoded()
+	require.Same(t, &first[0], &transcoded()[0])
+	require.NoError(t, os.Chtimes(filename, time.Now(), time.Now().Add(time.Minute)))
+	changed := transcoded()
+	require.NotSame(t, &first[0], &changed[0])
+	require.Equal(t, first, changed)
+	stat, err := os.Stat(filename)
+	require.NoError(t, err)
+	_, _, err = cache.get(filename, "flac", stat)
+	require.Error(t, err)
+	require.Len(t, cache.entries, 2)
+
+	// Unsupported formats are rejected before the file is decoded, here a header without frames
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "header.qoa"), qoaData[:8], 0o644))
+	s := &libraryServer{root: root, transcodes: newTranscodeCache()}
+	req = httptest.NewRequest(http.MethodGet, "/files/header.qoa?format=flac", nil)
+	req.SetPathValue("path", "header.qoa")
+	rec := httptest.NewRecorder()
+	s.handleFile(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "unsupported format")
+	require.Empty(t, s.transcodes.entries)
+
+	// Metadata comes from the file header
+	resp, err = http.Get(server.URL + "/api/files/wav/test.qoa")
+	require.NoError(t, err)
+	var info qoaFileInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	resp.Body.Close()
+	require.Equal(t, "wav/test.qoa", info.Path)
+	require.Equal(t, uint32(48000), info.SampleRate)
+	require.Equal(t, uint32(2), info.Channels)
+
+	// Files outside of the served directory are not found
+	resp, err = http.Get(server.URL + "/files/%2e%2e/goqoa_test.go")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestWatchCmd(t *testing.T) {
+	inDir := filepath.Join(t.TempDir(), "in")
+	outDir := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, os.MkdirAll(filepath.Join(inDir, "sub"), 0o755))
+	source, err := os.ReadFile("testdata/wav/test.wav")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(inDir, "sub", "test.wav"), source, 0o644))
+
+	args := []string{"watch", "--once", "--delete", "--out-dir", outDir, inDir}
+	_, err = execute(t, rootCmd, args...)
+	require.NoError(t, err)
+
+	// The directory structure is mirrored
+	outputFilename := filepath.Join(outDir, "sub", "test.qoa")
+	expected, err := os.ReadFile("testdata/wav/test.wav.qoa")
+	require.NoError(t, err)
+	actual, err := os.ReadFile(outputFilename)
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+
+	// Finished work is not redone
+	require.NoError(t, os.Remove(outputFilename))
+	_, err = execute(t, rootCmd, args...)
+	require.NoError(t, err)
+	require.NoFileExists(t, outputFilename)
+
+	// Outputs of removed sources are deleted
+	require.NoError(t, os.WriteFile(outputFilename, actual, 0o644))
+	require.NoError(t, os.Remove(filepath.Join(inDir, "sub", "test.wav")))
+	_, err = execute(t, rootCmd, args...)
+	require.NoError(t, err)
+	require.NoFileExists(t, outputFilename)
+
+	// Failures are recorded and only tried again once the file changes
+	brokenFilename := filepath.Join(inDir, "broken.wav")
+	require.NoError(t, os.WriteFile(brokenFilename, make([]byte, len(source)), 0o644))
+	stat, err := os.Stat(brokenFilename)
+	require.NoError(t, err)
+	w, err := newWatcher(watchOptions{inDir: inDir, outDir: outDir, to: ".qoa", stateFile: filepath.Join(outDir, ".goqoa-watch.json")})
+	require.NoError(t, err)
+	require.NoError(t, w.scan(time.Now(), true))
+	require.NotEmpty(t, w.done["broken.wav"].Error)
+
+	// The same size and modification time look unchanged, even after a restart
+	require.NoError(t, os.WriteFile(brokenFilename, source, 0o644))
+	require.NoError(t, os.Chtimes(brokenFilename, stat.ModTime(), stat.ModTime()))
+	w, err = newWatcher(w.opts)
+	require.NoError(t, err)
+	require.NoError(t, w.scan(time.Now(), true))
+	require.NoFileExists(t, filepath.Join(outDir, "broken.qoa"))
+
+	require.NoError(t, os.Chtimes(brokenFilename, time.Now(), stat.ModTime().Add(time.Minute)))
+	require.NoError(t, w.scan(time.Now(), true))
+	require.FileExists(t, filepath.Join(outDir, "broken.qoa"))
+	require.Empty(t, w.done["broken.wav"].Error)
+
+	// Outputs can be written next to their sources
+	require.NoError(t, os.WriteFile(filepath.Join(inDir, "sub", "test.wav"), source, 0o644))
+	w, err = newWatcher(watchOptions{inDir: inDir, outDir: inDir, to: ".qoa", stateFile: filepath.Join(outDir, "in-place.json")})
+	require.NoError(t, err)
+	require.NoError(t, w.scan(time.Now(), true))
+	require.FileExists(t, filepath.Join(inDir, "sub", "test.qoa"))
+
+	// Failing to save the state doesn't stop the other files converting
+	otherDir := t.TempDir()
+	for _, name := range []string{"a.wav", "b.wav"} {
+		require.NoError(t, os.WriteFile(filepath.Join(otherDir, name), source, 0o644))
+	}
+	w, err = newWatcher(watchOptions{inDir: otherDir, outDir: outDir, to: ".qoa", stateFile: filepath.Join(otherDir, "missing", "state.json")})
+	require.NoError(t, err)
+	require.NoError(t, w.scan(time.Now(), true))
+	require.FileExists(t, filepath.Join(outDir, "a.qoa"))
+	require.FileExists(t, filepath.Join(outDir, "b.qoa"))
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	require.Eventually(t, cond, 10*time.Second, time.Millisecond)
+}
+
+func TestPlayerOutput(t *testing.T) {
+	setupLogger()
+	expected, _, err := decodeAudio("testdata/wav/test.qoa")
+	require.NoError(t, err)
+
+	// The WAV output records exactly what is played
+	outputFilename := filepath.Join(t.TempDir(), "played.wav")
+	output, err := newAudioOutput(outputFilename, 48000, 2, 0)
+	require.NoError(t, err)
+	qp := newQOAPlayer(output)
+	qp.load(0, "testdata/wav/test.qoa")
+	qp.player.Play()
+	waitFor(t, func() bool { return !qp.player.IsPlaying() })
+	require.Equal(t, 1.0, qp.getPlayerProgress())
+	require.NoError(t, output.Close())
+
+	played, _, err := decodeAudio(outputFilename)
+	require.NoError(t, err)
+	require.Equal(t, expected, played)
+
+	// The TUI runs without sound hardware
+	output, err = newAudioOutput("null", 48000, 2, 0)
+	require.NoError(t, err)
+	defer output.Close()
+	m := initialModel(&playlist{filenames: []string{"testdata/wav/test.qoa"}, order: newPlayOrder(1, false, repeatOff)}, output, nil)
+	require.True(t, m.qoaPlayer.player.IsPlaying())
+	m.Update(tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}})
+	require.False(t, m.qoaPlayer.player.IsPlaying())
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'s'}})
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'r'}})
+	require.Contains(t, m.View(), "shuffle: on | repeat: all")
+
+	_, err = newAudioOutput("speakers.mp3", 48000, 2, 0)
+	require.Error(t, err)
+}
+
+func TestMinimalPlayer(t *testing.T) {
+	setupLogger()
+	files := []string{"testdata/wav/test.qoa", "testdata/flac/test.qoa"}
+
+	// Without input, the whole playlist plays through
+	output, err := newAudioOutput("null", 48000, 2, 0)
+	require.NoError(t, err)
+	defer output.Close()
+	var out bytes.Buffer
+	mp := &minimalPlayer{filenames: files, output: output, keys: helpKeys, out: &out, songs: &playlist{filenames: files, order: newPlayOrder(2, false, repeatOff)}}
+	mp.run(nil, nil)
+	require.Contains(t, out.String(), "[1/2] testdata/wav/test.qoa")
+	require.Contains(t, out.String(), "[2/2] testdata/flac/test.qoa")
+	require.True(t, strings.HasSuffix(out.String(), "Playback complete\n"))
+
+	// Keys skip and quit
+	output, err = newAudioOutput("null", 48000, 2, 1)
+	require.NoError(t, err)
+	defer output.Close()
+	out.Reset()
+	mp = &minimalPlayer{filenames: files, output: output, keys: helpKeys, out: &out, songs: &playlist{filenames: files, order: newPlayOrder(2, false, repeatOff)}}
+	keys := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		mp.run(keys, nil)
+		close(done)
+	}()
+	keys <- "n"
+	keys <- " "
+	// g asks for a time to go to, and keys are typed into it until enter
+	for _, k := range []string{"g", "0", ".", "5", "q", "backspace", "enter", "q"} {
+		keys <- k
+	}
+	<-done
+	require.Contains(t, out.String(), "[2/2] testdata/flac/test.qoa")
+	require.Contains(t, out.String(), "Paused at")
+	require.Contains(t, out.String(), "Go to: 0.5q\b \b")
+	require.Equal(t, 500*time.Millisecond, mp.qoaPlayer.position())
+	require.NotContains(t, out.String(), "Playback complete")
+
+	require.Equal(t, []string{"up", "q", "esc", " ", "ctrl+c", "backspace"}, parseKeys([]byte("\x1b[Aq\x1b \x03\x7f")))
+}
+
+func TestSkipSongs(t *testing.T) {
+	setupLogger()
+	output, err := newAudioOutput("null", 48000, 2, 1)
+	require.NoError(t, err)
+	defer output.Close()
+	files := []string{"testdata/wav/test.qoa", "testdata/flac/test.qoa", "testdata/wav/test.qoa"}
+	m := initialModel(&playlist{filenames: files, order: newPlayOrder(3, false, repeatOff)}, output, nil)
+	press := func(r rune) {
+		updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		*m = updated.(model)
+	}
+
+	// Moving through the list doesn't change what is playing
+	press('j')
+	require.Equal(t, 1, m.fileList.Index())
+	require.Equal(t, 0, m.currentIndex)
+
+	// n and b skip songs
+	press('n')
+	press('n')
+	require.Equal(t, 2, m.currentIndex)
+	press('n')
+	require.Equal(t, 2, m.currentIndex)
+	press('b')
+	require.Equal(t, 1, m.currentIndex)
+
+	// Going back a few seconds into a song restarts it instead
+	press('n')
+	m.qoaPlayer.seekTo(3500 * time.Millisecond)
+	press('b')
+	require.Equal(t, 2, m.currentIndex)
+	require.Less(t, m.qoaPlayer.position(), time.Second)
+	press('b')
+	require.Equal(t, 1, m.currentIndex)
+}
+
+func TestVisualizer(t *testing.T) {
+	setupLogger()
+	// The queue keeps what it read, so what the player is about to play can be shown
+	output, err := newAudioOutput("null", 48000, 2, 0)
+	require.NoError(t, err)
+	defer output.Close()
+	tr, err := loadTrack(0, "testdata/wav/test.qoa", output)
+	require.NoError(t, err)
+	q := newTrackQueue(2)
+	q.play(tr)
+	read := make([]byte, 1000*4)
+	_, err = io.ReadFull(q, read)
+	require.NoError(t, err)
+	heard := make([]int16, 100*2)
+	require.Equal(t, 100, q.heardSamples(400*4, heard))
+	for i, s := range heard {
+		require.Equal(t, int16(binary.LittleEndian.Uint16(read[(500*2+i)*2:])), s)
+	}
+	require.Equal(t, 600, q.heardSamples(400*4, make([]int16, 1000*2)))
+
+	// A full scale sine peaks at 0 dB, 3 dB above its RMS level
+	v := newVisualizer(48000, 2)
+	sine, _, err := generateSignal("sine", signalOptions{SampleRate: 48000, Duration: 100 * time.Millisecond, Amplitude: 1, Frequency: 1000})
+	require.NoError(t, err)
+	sine = sine[:spectrumSize*2]
+	for i := 1; i < len(sine); i += 2 {
+		sine[i] /= 10
+	}
+	start := time.Now()
+	v.update(sine, start)
+	require.InDelta(t, 0, v.peak[0], 0.01)
+	require.InDelta(t, -3.01, v.rms[0], 0.05)
+	require.InDelta(t, -20, v.peak[1], 0.01)
+	meters := v.render(60)
+	require.Equal(t, 2, strings.Count(meters, "\n")+1)
+	require.Contains(t, meters, "0.0 dB")
+	require.Contains(t, meters, "-20.0 dB")
+
+	// The peak holds through silence, then falls
+	v.update(nil, start.Add(time.Second))
+	require.InDelta(t, 0, v.hold[0], 0.01)
+	v.update(nil, start.Add(2*time.Second))
+	require.InDelta(t, -30, v.hold[0], 0.01)
+
+	// The spectrum peaks at the sine's frequency
+	v.toggle()
+	v.update(sine, start.Add(3*time.Second))
+	loudest := 0
+	for i, level := range v.spectrum {
+		if level > v.spectrum[loudest] {
+			loudest = i
+		}
+	}
+	require.InDelta(t, 1000, float64(loudest)*48000/spectrumSize, 48000/spectrumSize)
+	spectrum := v.render(60)
+	require.Equal(t, spectrumHeight, strings.Count(spectrum, "\n")+1)
+	require.Contains(t, spectrum, "█")
+
+	// The TUI switches between them with v
+	m := initialModel(&playlist{filenames: []string{"testdata/wav/test.qoa"}, order: newPlayOrder(1, false, repeatOff)}, output, nil)
+	require.Contains(t, m.View(), "-inf dB")
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'v'}})
+	require.Equal(t, visualSpectrum, updated.(model).visualizer.mode)
+	require.NotContains(t, updated.View(), "-inf dB")
+}
+
+func TestPlayOrder(t *testing.T) {
+	// In order, repeat off ends the playlist
+	o := newPlayOrder(3, false, repeatOff)
+	require.Equal(t, 0, o.current())
+	o.moveTo(2)
+	_, ok := o.next(true)
+	require.False(t, ok)
+	require.Equal(t, 1, o.previous())
+
+	// Repeat one repeats songs that end. Skipping goes on to the next, round the playlist
+	o.repeat = repeatOne
+	next, ok := o.next(true)
+	require.True(t, ok)
+	require.Equal(t, 2, next)
+	next, ok = o.next(false)
+	require.True(t, ok)
+	require.Equal(t, 0, next)
+
+	// Shuffled, every song plays once before any plays again, and never twice in a row
+	o = newPlayOrder(5, true, repeatAll)
+	played := []int{o.current()}
+	for range 14 {
+		next, ok := o.next(true)
+		require.True(t, ok)
+		require.NotEqual(t, played[len(played)-1], next)
+		o.moveTo(next)
+		played = append(played, next)
+	}
+	for cycle := 0; cycle < len(played); cycle += 5 {
+		require.ElementsMatch(t, []int{0, 1, 2, 3, 4}, played[cycle:cycle+5])
+	}
+
+	// Turning shuffle off keeps the current song playing
+	current := o.current()
+	o.setShuffle(false)
+	require.Equal(t, current, o.current())
+
+	o.cycleRepeat()
+	require.Equal(t, repeatOne, o.repeat)
+	o.cycleRepeat()
+	require.Equal(t, repeatOff, o.repeat)
+	_, err := parseRepeatMode("sometimes")
+	require.Error(t, err)
+}
+
+func TestPlayerVolume(t *testing.T) {
+	setupLogger()
+	settingsFile := filepath.Join(t.TempDir(), "goqoa", "player.json")
+	settings, err := loadPlayerSettings(settingsFile)
+	require.NoError(t, err)
+	require.Equal(t, 1.0, settings.Volume)
+
+	// Volume keys change the volume, and it is saved for next time
+	output, err := newAudioOutput("null", 48000, 2, 1)
+	require.NoError(t, err)
+	defer output.Close()
+	m := initialModel(&playlist{filenames: []string{"testdata/wav/test.qoa"}, order: newPlayOrder(1, false, repeatOff)}, output, settings)
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'-'}})
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'-'}})
+	require.InDelta(t, 0.9, m.qoaPlayer.player.Volume(), 1e-9)
+	require.Contains(t, m.View(), "volume: 90%")
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'m'}})
+	require.Zero(t, m.qoaPlayer.player.Volume())
+	require.Contains(t, m.View(), "volume: muted")
+
+	saved, err := loadPlayerSettings(settingsFile)
+	require.NoError(t, err)
+	require.InDelta(t, 0.9, saved.Volume, 1e-9)
+	require.True(t, saved.Muted)
+
+	// The sinks play at the volume too
+	expected, _, err := decodeAudio("testdata/wav/test.qoa")
+	require.NoError(t, err)
+	outputFilename := filepath.Join(t.TempDir(), "played.wav")
+	output, err = newAudioOutput(outputFilename, 48000, 2, 0)
+	require.NoError(t, err)
+	qp := newQOAPlayer(output)
+	qp.useSettings(&playerSettings{Volume: 0.5})
+	qp.load(0, "testdata/wav/test.qoa")
+	qp.player.Play()
+	waitFor(t, func() bool { return !qp.player.IsPlaying() })
+	require.NoError(t, output.Close())
+	played, _, err := decodeAudio(outputFilename)
+	require.NoError(t, err)
+	require.Len(t, played, len(expected))
+	for i := range played {
+		require.InDelta(t, float64(expected[i])/2, float64(played[i]), 1)
+	}
+}
+
+func TestAbsoluteSeek(t *testing.T) {
+	setupLogger()
+	output, err := newAudioOutput("null", 48000, 2, 1)
+	require.NoError(t, err)
+	defer output.Close()
+	m := initialModel(&playlist{filenames: []string{"testdata/wav/test.qoa"}, order: newPlayOrder(1, false, repeatOff)}, output, nil)
+	qp := m.qoaPlayer
+	qp.player.Pause()
+	heard := func() int64 {
+		_, frame := qp.queue.heard(qp.player.BufferedSize())
+		return frame
+	}
+
+	// Seeks land on the exact sample
+	qp.seekTo(1500 * time.Millisecond)
+	require.Equal(t, int64(72000), heard())
+	qp.seekToPercent(0.5)
+	require.Equal(t, int64(qp.qoaMetadata.Samples/2), heard())
+	qp.seekTo(time.Hour)
+	require.Equal(t, int64(qp.qoaMetadata.Samples), heard())
+
+	// Number keys jump to a tenth of the song
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'3'}})
+	require.Equal(t, int64(math.Round(float64(qp.qoaMetadata.Samples)*0.3)), heard())
+
+	// g asks for a time to go to
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'g'}})
+	require.Contains(t, updated.View(), "Go to:")
+	for _, r := range "0:02.25" {
+		updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.Equal(t, int64(108000), heard())
+	require.NotContains(t, updated.View(), "Go to:")
+
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'g'}})
+	for _, r := range "soon" {
+		updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.Equal(t, int64(108000), heard())
+	require.Contains(t, updated.View(), `invalid timestamp "soon"`)
+
+	// The song's waveform is drawn in place of a progress bar once its overview is computed,
+	// fitted to the screen
+	waitFor(t, func() bool {
+		_, ok := m.overviews.get(qp.filename)
+		return ok
+	})
+	updated, _ = updated.Update(tea.WindowSizeMsg{Width: 160, Height: 50})
+	width := updated.(model).progress.Width
+	waveform := updated.(model).waveform
+	require.Len(t, waveform, width)
+	loudest := 0
+	for _, c := range waveform {
+		loudest = max(loudest, c)
+	}
+	require.Greater(t, loudest, waveformHeight*4)
+
+	barX, barY := -1, -1
+	bottom := strings.Split(drawWaveform(waveform, 0, -1, -1, -1), "\n")[waveformHeight-1]
+	for y, line := range strings.Split(updated.View(), "\n") {
+		if i := strings.Index(line, bottom); i >= 0 {
+			barX, barY = len([]rune(line[:i])), y-(waveformHeight-1)
+			break
+		}
+	}
+	require.GreaterOrEqual(t, barY, 0)
+
+	// Dragging the cursor along the waveform seeks where it's let go
+	qp.seekToPercent(0)
+	press := tea.MouseMsg{X: barX, Y: barY, Action: tea.MouseActionPress, Button: tea.MouseButtonLeft}
+	updated, _ = updated.Update(press)
+	updated, _ = updated.Update(tea.MouseMsg{X: barX + (width-1)/2, Y: barY + 5, Action: tea.MouseActionMotion, Button: tea.MouseButtonLeft})
+	require.Equal(t, (width-1)/2, updated.(model).scrub)
+	require.Zero(t, heard())
+	updated, _ = updated.Update(tea.MouseMsg{X: barX + (width-1)/2, Y: barY + 5, Action: tea.MouseActionRelease})
+	require.Equal(t, -1, updated.(model).scrub)
+	samplesPerColumn := float64(qp.qoaMetadata.Samples) / float64(width-1)
+	require.InDelta(t, float64((width-1)/2)*samplesPerColumn, float64(heard()), 1)
+
+	// Clicks off the waveform don't seek
+	for _, at := range [][2]int{{barX + width, barY}, {barX, barY + waveformHeight}, {barX - 1, barY}} {
+		updated, _ = updated.Update(tea.MouseMsg{X: at[0], Y: at[1], Action: tea.MouseActionPress, Button: tea.MouseButtonLeft})
+		updated, _ = updated.Update(tea.MouseMsg{X: at[0], Y: at[1], Action: tea.MouseActionRelease})
+	}
+	require.InDelta(t, float64((width-1)/2)*samplesPerColumn, float64(heard()), 1)
+
+	// [ and ] step the cursor a column at a time
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{']'}})
+	updated.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{']'}})
+	require.InDelta(t, float64((width-1)/2+2)*samplesPerColumn, float64(heard()), 1)
+}
+
+func TestPlaylists(t *testing.T) {
+	dir := t.TempDir()
+	wav, err := filepath.Abs("testdata/wav/test.qoa")
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "songs"), 0o755))
+	data, err := os.ReadFile("testdata/flac/test.qoa")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "songs", "flac.qoa"), data, 0o644))
+
+	// Extended M3U titles are kept, and relative paths are from the playlist
+	m3u := filepath.Join(dir, "review.m3u8")
+	require.NoError(t, os.WriteFile(m3u, []byte("#EXTM3U\n#EXTINF:1,Flac Take\nsongs/flac.qoa\n# a comment\nhttp://example.com/stream.qoa\n"+wav+"\n"), 0o644))
+	entries, err := readPlaylist(m3u)
+	require.NoError(t, err)
+	require.Equal(t, []playlistEntry{{path: filepath.Join(dir, "songs", "flac.qoa"), title: "Flac Take"}, {path: wav}}, entries)
+
+	// PLS entries are in number order
+	pls := filepath.Join(dir, "review.pls")
+	require.NoError(t, os.WriteFile(pls, []byte("[playlist]\nFile2=songs/flac.qoa\nFile1="+wav+"\nTitle1=Wav Take\nNumberOfEntries=2\n"), 0o644))
+	entries, err = readPlaylist(pls)
+	require.NoError(t, err)
+	require.Equal(t, []playlistEntry{{path: wav, title: "Wav Take"}, {path: filepath.Join(dir, "songs", "flac.qoa")}}, entries)
+
+	// Saved playlists read back in play order
+	songs := &playlist{
+		filenames: []string{entries[0].path, entries[1].path},
+		titles:    []string{entries[0].title, ""},
+		order:     newPlayOrder(2, false, repeatOff),
+		saveAs:    filepath.Join(dir, "saved.m3u"),
+	}
+	songs.order.order = []int{1, 0}
+	require.NoError(t, songs.save())
+	saved, err := os.ReadFile(songs.saveAs)
+	require.NoError(t, err)
+	require.Contains(t, string(saved), "#EXTINF:3,Wav Take\n"+filepath.ToSlash(wav)+"\n")
+	require.Contains(t, string(saved), "#EXTINF:0,flac\nsongs/flac.qoa\n")
+	entries, err = readPlaylist(songs.saveAs)
+	require.NoError(t, err)
+	require.Equal(t, []playlistEntry{{path: songs.filenames[1], title: "flac"}, {path: wav, title: "Wav Take"}}, entries)
+
+	_, err = execute(t, rootCmd, "play", "-n", "-o", "null", "--output-speed", "0", m3u)
+	require.NoError(t, err)
+}
+
+func TestGaplessPlayback(t *testing.T) {
+	setupLogger()
+	files := []string{"testdata/wav/test.qoa", "testdata/flac/test.qoa"}
+	var expected []int16
+	for _, file := range files {
+		samples, _, err := decodeAudio(file)
+		require.NoError(t, err)
+		expected = append(expected, samples...)
+	}
+
+	// The next song follows the last without a single sample between them
+	outputFilename := filepath.Join(t.TempDir(), "played.wav")
+	output, err := newAudioOutput(outputFilename, 48000, 2, 0)
+	require.NoError(t, err)
+	qp := newQOAPlayer(output)
+	qp.load(0, files[0])
+	next, err := loadTrack(1, files[1], output)
+	require.NoError(t, err)
+	require.True(t, qp.queue.setNext(next, qp.queue.queue()))
+	qp.player.Play()
+	waitFor(t, func() bool { return !qp.player.IsPlaying() })
+	require.True(t, qp.ended())
+	require.Equal(t, 1, qp.index)
+	require.NoError(t, output.Close())
+
+	played, _, err := decodeAudio(outputFilename)
+	require.NoError(t, err)
+	require.Equal(t, expected, played)
+
+	// What is heard lags what is read by what the player buffered
+	queue := newTrackQueue(2)
+	first, err := loadTrack(0, files[0], output)
+	require.NoError(t, err)
+	next, err = loadTrack(1, files[1], output)
+	require.NoError(t, err)
+	queue.play(first)
+	require.True(t, queue.setNext(next, queue.queue()))
+	data, err := io.ReadAll(queue)
+	require.NoError(t, err)
+	require.Len(t, data, len(expected)*2)
+	heard, frame := queue.heard(int(next.stream.Length()+100) * 4)
+	require.Equal(t, first, heard)
+	require.Equal(t, first.stream.Length()-100, frame)
+
+	// Seeking in a song the player read past makes it current again, with the next one after it
+	queue.rewind(first)
+	_, err = queue.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	data, err = io.ReadAll(queue)
+	require.NoError(t, err)
+	require.Len(t, data, len(expected)*2)
+}
+
+func TestQOAStreamSource(t *testing.T) {
+	data, err := os.ReadFile("testdata/wav/test.qoa")
+	require.NoError(t, err)
+	q, expected, err := decodeQOA(data)
+	require.NoError(t, err)
+
+	// Frames are decoded as they are read, wherever the reads start
+	s, err := newQOAStreamSource(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, int(q.Samples), s.Frames())
+	for _, start := range []int{0, qoa.QOAFrameLen - 3, 5 * qoa.QOAFrameLen, s.Frames() - 10} {
+		dst := make([]int16, 2*qoa.QOAFrameLen)
+		n, err := s.ReadFrames(dst, start)
+		require.NoError(t, err)
+		require.Equal(t, min(qoa.QOAFrameLen, s.Frames()-start), n)
+		require.Equal(t, expected[start*2:(start+n)*2], dst[:n*2])
+	}
+
+	// A file cut short plays its whole frames
+	s, err = newQOAStreamSource(bytes.NewReader(data[:8+3*int(qoaFrameSize(qoa.QOAFrameLen, 2))+100]))
+	require.NoError(t, err)
+	require.Equal(t, 3*qoa.QOAFrameLen, s.Frames())
+	_, err = newQOAStreamSource(bytes.NewReader(data[:100]))
+	require.Error(t, err)
+
+	// A corrupt frame is an error when it's reached
+	corrupt := append([]byte(nil), data...)
+	corrupt[8+int(qoaFrameSize(qoa.QOAFrameLen, 2))] = 7
+	s, err = newQOAStreamSource(bytes.NewReader(corrupt))
+	require.NoError(t, err)
+	_, err = s.ReadFrames(make([]int16, 2), qoa.QOAFrameLen)
+	require.Error(t, err)
+}
+
+func TestPCMStream(t *testing.T) {
+	readAll := func(s *pcmStream) []int16 {
+		data, err := io.ReadAll(s)
+		require.NoError(t, err)
+		samples := make([]int16, len(data)/2)
+		for i := range samples {
+			samples[i] = int16(binary.LittleEndian.Uint16(data[i*2:]))
+		}
+		return samples
+	}
+
+	// Matching formats pass through untouched
+	samples, _, err := generateSignal("white", signalOptions{SampleRate: 48000, Channels: 2, Duration: 100 * time.Millisecond, Seed: 1})
+	require.NoError(t, err)
+	s := newPCMStream(&memorySource{samples: samples, sampleRate: 48000, channels: 2}, 48000, 2)
+	require.Equal(t, samples, readAll(s))
+
+	// Mono 22050 Hz is upmixed and resampled without changing pitch
+	samples, _, err = generateSignal("sine", signalOptions{SampleRate: 22050, Channels: 1, Duration: time.Second, Frequency: 1000})
+	require.NoError(t, err)
+	s = newPCMStream(&memorySource{samples: samples, sampleRate: 22050, channels: 1}, 48000, 2)
+	require.EqualValues(t, 48000, s.Length())
+	resampled := readAll(s)
+	require.Len(t, resampled, 48000*2)
+	crossings, peak := 0, 0
+	for i := 2; i < len(resampled); i += 2 {
+		require.Equal(t, resampled[i], resampled[i+1])
+		if (resampled[i-2] < 0) != (resampled[i] < 0) {
+			crossings++
+		}
+		peak = max(peak, abs(int(resampled[i])))
+	}
+	require.InDelta(t, 2000, crossings, 4)
+	require.InDelta(t, 0.5*32767, peak, 500)
+
+	// Seeks are in bytes, and clamped to the track
+	pos, err := s.Seek(-4*100, io.SeekEnd)
+	require.NoError(t, err)
+	require.EqualValues(t, (48000-100)*4, pos)
+	pos, err = s.Seek(1<<30, io.SeekCurrent)
+	require.NoError(t, err)
+	require.EqualValues(t, 48000*4, pos)
+	_, err = s.Read(make([]byte, 4))
+	require.ErrorIs(t, err, io.EOF)
+
+	// 5.1 is mixed down to stereo by speaker position, without the LFE
+	matrix := channelMatrix(6, 2)
+	require.Zero(t, matrix[0][3])
+	require.Zero(t, matrix[0][1])
+	require.Zero(t, matrix[1][0])
+	require.Equal(t, matrix[0][2], matrix[1][2])
+	require.InDelta(t, 1, matrix[0][0]+matrix[0][2]+matrix[0][4], 1e-9)
+	require.Equal(t, [][]float64{{0.5, 0.5}}, channelMatrix(2, 1))
+}
+
+func TestPlaybackSpeed(t *testing.T) {
+	setupLogger()
+	samples, _, err := generateSignal("sine", signalOptions{SampleRate: 48000, Channels: 2, Duration: 2 * time.Second, Frequency: 440})
+	require.NoError(t, err)
+	level := 0.0
+	for _, v := range samples {
+		level += float64(v) * float64(v)
+	}
+	level = toDBFS(level / float64(len(samples)))
+
+	// measure reads s to the end, returning its length in frames and the frequency it plays at.
+	// The level should stay that of the sine, as the overlaps shouldn't cancel out.
+	measure := func(s *pcmStream) (int, float64) {
+		data, err := io.ReadAll(s)
+		require.NoError(t, err)
+		frames := len(data) / 4
+		crossings, sum := 0, 0.0
+		for i := 1; i < frames; i++ {
+			a, b := int16(binary.LittleEndian.Uint16(data[(i-1)*4:])), int16(binary.LittleEndian.Uint16(data[i*4:]))
+			if (a < 0) != (b < 0) {
+				crossings++
+			}
+			sum += float64(b) * float64(b)
+		}
+		require.InDelta(t, level, toDBFS(sum/float64(frames-1)), 0.5)
+		return frames, float64(crossings) / 2 / (float64(frames) / 48000)
+	}
+	for _, speed := range []float64{0.5, 1.3, 2} {
+		// Time stretching keeps the pitch
+		s := newPCMStream(&memorySource{samples: samples, sampleRate: 48000, channels: 2}, 48000, 2)
+		s.setSpeed(speed, false)
+		frames, frequency := measure(s)
+		require.InDelta(t, 96000/speed, frames, 2, "speed %v", speed)
+		require.InDelta(t, 440, frequency, 440*0.02, "speed %v", speed)
+
+		// Varispeed changes it
+		s = newPCMStream(&memorySource{samples: samples, sampleRate: 48000, channels: 2}, 48000, 2)
+		s.setSpeed(speed, true)
+		frames, frequency = measure(s)
+		require.InDelta(t, 96000/speed, frames, 2, "speed %v", speed)
+		require.InDelta(t, 440*speed, frequency, 440*speed*0.02, "speed %v", speed)
+	}
+
+	// What is heard is tracked in the song's time, whatever the speed it was read at
+	output, err := newAudioOutput("null", 48000, 2, 0)
+	require.NoError(t, err)
+	defer output.Close()
+	tr, err := loadTrack(0, "testdata/wav/test.qoa", output)
+	require.NoError(t, err)
+	q := newTrackQueue(2)
+	q.play(tr)
+	_, err = io.ReadFull(q, make([]byte, 1000*4))
+	require.NoError(t, err)
+	q.setSpeed(2, true)
+	_, err = io.ReadFull(q, make([]byte, 1000*4))
+	require.NoError(t, err)
+	_, frame := q.heard(1500 * 4)
+	require.EqualValues(t, 500, frame)
+	_, frame = q.heard(500 * 4)
+	require.EqualValues(t, 2000, frame)
+	_, frame = q.heard(0)
+	require.EqualValues(t, 3000, frame)
+
+	// The speed keys change the speed in steps, within limits
+	m := initialModel(&playlist{filenames: []string{"testdata/wav/test.qoa"}, order: newPlayOrder(1, false, repeatOff)}, output, nil)
+	press := func(r rune, times int) {
+		for range times {
+			updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+			*m = updated.(model)
+		}
+	}
+	require.Contains(t, m.View(), "speed: 1.0x")
+	press('>', 5)
+	require.Contains(t, m.View(), "speed: 1.5x")
+	press('t', 1)
+	require.Contains(t, m.View(), "speed: 1.5x varispeed")
+	press('<', 20)
+	require.Contains(t, m.View(), "speed: 0.5x varispeed")
+	require.Equal(t, 0.5, m.qoaPlayer.queue.speed)
+}
+
+func TestLoopRegion(t *testing.T) {
+	setupLogger()
+	samples := make([]int16, 48000*2)
+	for i := range samples {
+		samples[i] = int16(i)
+	}
+
+	// Reading across the end of the loop goes straight back to its start, with no gap
+	s := newPCMStream(&memorySource{samples: samples, sampleRate: 48000, channels: 2}, 48000, 2)
+	s.setLoop(1000, 1100, true)
+	_, err := s.Seek(1090*4, io.SeekStart)
+	require.NoError(t, err)
+	data := make([]byte, 20*4)
+	n, err := s.Read(data)
+	require.NoError(t, err)
+	require.Equal(t, 10*4, n)
+	require.EqualValues(t, 1099*2, int16(binary.LittleEndian.Uint16(data[9*4:])))
+	_, err = s.Read(data)
+	require.ErrorIs(t, err, errLooped)
+	n, err = s.Read(data)
+	require.NoError(t, err)
+	require.Equal(t, 20*4, n)
+	require.EqualValues(t, 1000*2, int16(binary.LittleEndian.Uint16(data)))
+	require.EqualValues(t, 1000*2+1, int16(binary.LittleEndian.Uint16(data[2:])))
+
+	// What is heard jumps back with the loop
+	output, err := newAudioOutput("null", 48000, 2, 0)
+	require.NoError(t, err)
+	defer output.Close()
+	tr, err := loadTrack(0, "testdata/wav/test.qoa", output)
+	require.NoError(t, err)
+	tr.stream.setLoop(0, 1000, true)
+	q := newTrackQueue(2)
+	q.play(tr)
+	_, err = io.ReadFull(q, make([]byte, 2500*4))
+	require.NoError(t, err)
+	_, frame := q.heard(1600 * 4)
+	require.EqualValues(t, 900, frame)
+	_, frame = q.heard(1000 * 4)
+	require.EqualValues(t, 500, frame)
+	_, frame = q.heard(0)
+	require.EqualValues(t, 500, frame)
+
+	// The keys set the loop points at the position heard and turn looping on and off
+	m := initialModel(&playlist{filenames: []string{"testdata/wav/test.qoa"}, order: newPlayOrder(1, false, repeatOff)}, output, nil)
+	press := func(r rune) {
+		updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		*m = updated.(model)
+	}
+	press(' ')
+	require.Contains(t, m.View(), "loop: off")
+	m.qoaPlayer.seekToFrame(48000)
+	press('A')
+	m.qoaPlayer.seekToFrame(96000)
+	press('B')
+	require.Contains(t, m.View(), "loop: 1.00s-2.00s (off)")
+	press('L')
+	require.Contains(t, m.View(), "loop: 1.00s-2.00s")
+	require.NotContains(t, m.View(), "(off)")
+	start, end, looping := m.qoaPlayer.stream.loop()
+	require.EqualValues(t, []int64{48000, 96000}, []int64{start, end})
+	require.True(t, looping)
+	press('L')
+	require.Contains(t, m.View(), "loop: 1.00s-2.00s (off)")
+
+	// The flags loop the first song from the start of the loop, up to the end of the song if
+	// there's no end
+	m = initialModel(&playlist{filenames: []string{"testdata/wav/test.qoa"}, order: newPlayOrder(1, false, repeatOff)}, output, &playerSettings{Volume: 1, loop: true, loopStart: 500 * time.Millisecond})
+	start, end, looping = m.qoaPlayer.stream.loop()
+	require.EqualValues(t, []int64{24000, m.qoaPlayer.stream.Length()}, []int64{start, end})
+	require.True(t, looping)
+	require.EqualValues(t, 24000, m.qoaPlayer.heardFrame())
+}
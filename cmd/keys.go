@@ -3,16 +3,38 @@ package cmd
 import "github.com/charmbracelet/bubbles/key"
 
 type helpKeyMap struct {
-	togglePlay     key.Binding
-	quit           key.Binding
-	seek           key.Binding
-	seekBack       key.Binding
-	seekForward    key.Binding
-	selectSong     key.Binding
-	previousSong   key.Binding
-	nextSong       key.Binding
-	pickSong       key.Binding
-	toggleAutoplay key.Binding
+	togglePlay      key.Binding
+	quit            key.Binding
+	seek            key.Binding
+	seekBack        key.Binding
+	seekForward     key.Binding
+	selectSong      key.Binding
+	skipSong        key.Binding
+	previousSong    key.Binding
+	nextSong        key.Binding
+	pickSong        key.Binding
+	toggleAutoplay  key.Binding
+	toggleShuffle   key.Binding
+	cycleRepeat     key.Binding
+	savePlaylist    key.Binding
+	volume          key.Binding
+	volumeUp        key.Binding
+	volumeDown      key.Binding
+	mute            key.Binding
+	jump            key.Binding
+	goTo            key.Binding
+	visualize       key.Binding
+	step            key.Binding
+	stepBack        key.Binding
+	stepForward     key.Binding
+	speed           key.Binding
+	speedUp         key.Binding
+	speedDown       key.Binding
+	toggleVarispeed key.Binding
+	loopPoints      key.Binding
+	setLoopStart    key.Binding
+	setLoopEnd      key.Binding
+	toggleLoop      key.Binding
 }
 
 var helpKeys = helpKeyMap{
@@ -38,20 +60,100 @@ var helpKeys = helpKeyMap{
 		key.WithKeys("up", "k", "down", "j"),
 		key.WithHelp("up/k/down/j", "choose song"),
 	),
+	skipSong: key.NewBinding(
+		key.WithKeys("b", "n"),
+		key.WithHelp("b/n", "previous/next song"),
+	),
 	previousSong: key.NewBinding(
-		key.WithKeys("up", "k"),
+		key.WithKeys("b"),
 	),
 	nextSong: key.NewBinding(
-		key.WithKeys("down", "j"),
+		key.WithKeys("n"),
 	),
 	pickSong: key.NewBinding(
-		key.WithKeys("enter", "j"),
+		key.WithKeys("enter"),
 		key.WithHelp("enter", "pick song"),
 	),
 	toggleAutoplay: key.NewBinding(
 		key.WithKeys("tab"),
 		key.WithHelp("tab", "toggle autoplay"),
 	),
+	toggleShuffle: key.NewBinding(
+		key.WithKeys("s"),
+		key.WithHelp("s", "toggle shuffle"),
+	),
+	cycleRepeat: key.NewBinding(
+		key.WithKeys("r"),
+		key.WithHelp("r", "repeat off/all/one"),
+	),
+	savePlaylist: key.NewBinding(
+		key.WithKeys("w"),
+		key.WithHelp("w", "save playlist"),
+	),
+	volume: key.NewBinding(
+		key.WithKeys("+", "=", "-"),
+		key.WithHelp("+/-", "volume"),
+	),
+	volumeUp: key.NewBinding(
+		key.WithKeys("+", "="),
+	),
+	volumeDown: key.NewBinding(
+		key.WithKeys("-"),
+	),
+	mute: key.NewBinding(
+		key.WithKeys("m"),
+		key.WithHelp("m", "mute"),
+	),
+	jump: key.NewBinding(
+		key.WithKeys("0", "1", "2", "3", "4", "5", "6", "7", "8", "9"),
+		key.WithHelp("0-9", "jump to 0-90%"),
+	),
+	goTo: key.NewBinding(
+		key.WithKeys("g"),
+		key.WithHelp("g", "go to time"),
+	),
+	visualize: key.NewBinding(
+		key.WithKeys("v"),
+		key.WithHelp("v", "meters/spectrum"),
+	),
+	step: key.NewBinding(
+		key.WithKeys("[", "]"),
+		key.WithHelp("[/]", "step along waveform"),
+	),
+	stepBack: key.NewBinding(
+		key.WithKeys("["),
+	),
+	stepForward: key.NewBinding(
+		key.WithKeys("]"),
+	),
+	speed: key.NewBinding(
+		key.WithKeys(">", ".", "<", ","),
+		key.WithHelp("</>", "speed"),
+	),
+	speedUp: key.NewBinding(
+		key.WithKeys(">", "."),
+	),
+	speedDown: key.NewBinding(
+		key.WithKeys("<", ","),
+	),
+	toggleVarispeed: key.NewBinding(
+		key.WithKeys("t"),
+		key.WithHelp("t", "varispeed"),
+	),
+	loopPoints: key.NewBinding(
+		key.WithKeys("A", "B"),
+		key.WithHelp("A/B", "set loop start/end"),
+	),
+	setLoopStart: key.NewBinding(
+		key.WithKeys("A"),
+	),
+	setLoopEnd: key.NewBinding(
+		key.WithKeys("B"),
+	),
+	toggleLoop: key.NewBinding(
+		key.WithKeys("L"),
+		key.WithHelp("L", "loop A-B"),
+	),
 }
 
 func (k helpKeyMap) ShortHelp() []key.Binding {
@@ -59,8 +161,10 @@ func (k helpKeyMap) ShortHelp() []key.Binding {
 }
 func (k helpKeyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
-		{k.togglePlay, k.seek, k.toggleAutoplay},
-		{k.selectSong, k.pickSong},
+		{k.togglePlay, k.seek, k.toggleAutoplay, k.visualize, k.speed, k.toggleVarispeed},
+		{k.volume, k.mute, k.jump, k.goTo, k.step},
+		{k.toggleShuffle, k.cycleRepeat, k.savePlaylist, k.loopPoints, k.toggleLoop},
+		{k.skipSong, k.selectSong, k.pickSong},
 		{k.quit},
 	}
 }
@@ -2,102 +2,394 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	tea "github.com/charmbracelet/bubbletea"
-	"github.com/ebitengine/oto/v3"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/x/term"
 )
 
-type minimalModel struct {
-	player    *qoaPlayer
-	lastTick  time.Time
-	isPlaying bool
+// minimalPlayer plays a playlist without the TUI. It prints a line per track and reads
+// single key controls, so it also works over SSH or with its output piped to a log.
+type minimalPlayer struct {
+	filenames []string
+	// currentIndex is the index of the song playing
+	currentIndex int
+	qoaPlayer    *qoaPlayer
+	output       audioOutput
+	keys         helpKeyMap
+	out          io.Writer
+	// tty is set when out is a terminal, to show a live time display
+	tty bool
+	// raw is set when the terminal is in raw mode, where lines must end in \r\n
+	raw bool
+	// paused is set when the user paused, as opposed to the track ending
+	paused   bool
+	lastTick time.Time
+	// songs is the playlist, with the order songs play in
+	songs *playlist
+	// settings are the saved settings like the volume, or nil for the defaults
+	settings *playerSettings
+	// heard is the track last heard, to notice when the next one starts
+	heard *track
+	// typed is what has been typed at the go to prompt, while prompting is set
+	typed     string
+	prompting bool
 }
 
-func startMinimalPlayer(filename string) {
-	fmt.Println("Starting minimal player mode...")
+func startMinimalPlayer(songs *playlist, output audioOutput, settings *playerSettings) {
+	mp := &minimalPlayer{
+		filenames: songs.filenames,
+		output:    output,
+		songs:     songs,
+		settings:  settings,
+		keys:      helpKeys,
+		out:       os.Stdout,
+		tty:       term.IsTerminal(os.Stdout.Fd()),
+	}
+
+	// Read keys as they are pressed, instead of line by line
+	if term.IsTerminal(os.Stdin.Fd()) {
+		state, err := term.MakeRaw(os.Stdin.Fd())
+		if err == nil {
+			mp.raw = true
+			defer term.Restore(os.Stdin.Fd(), state)
+		}
+	}
 
 	// Set up clean exit handling
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		fmt.Print("\nUser interrupted, exiting...\n")
-		os.Exit(0)
-	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
 
-	p := tea.NewProgram(
-		initialMinimalModel(filename),
-		tea.WithoutRenderer(),
-	)
+	mp.run(readKeys(os.Stdin), signals)
+}
+
+// run plays until the playlist is done, or the user quits.
+func (mp *minimalPlayer) run(keys <-chan string, signals <-chan os.Signal) {
+	mp.printf("Playing %d songs (%s). Keys: %s\n", len(mp.filenames), mp.songs.order.describe(), mp.keyHelp())
+	mp.qoaPlayer = newQOAPlayer(mp.output)
+	mp.qoaPlayer.useSettings(mp.settings)
+	mp.loadSong(mp.songs.order.current())
+	mp.qoaPlayer.useLoopSettings()
 
-	if _, err := p.Run(); err != nil {
-		fmt.Printf("Error running player: %v\n", err)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-signals:
+			mp.printf("\nUser interrupted, exiting...\n")
+			mp.stop()
+			return
+		case k, ok := <-keys:
+			if !ok {
+				// Input is closed, keep playing without controls
+				keys = nil
+				continue
+			}
+			if !mp.handleKey(k) {
+				mp.stop()
+				return
+			}
+		case <-ticker.C:
+			if !mp.tick() {
+				return
+			}
+		}
 	}
 }
 
-func initialMinimalModel(filename string) minimalModel {
-	ctx, ready, err := oto.NewContext(&oto.NewContextOptions{
-		SampleRate:   44100,
-		ChannelCount: 2,
-		Format:       oto.FormatSignedInt16LE,
-	})
-	if err != nil {
-		fmt.Printf("Error creating audio context: %v\n", err)
-		return minimalModel{}
+// handleKey acts on a key press and reports whether to keep playing.
+func (mp *minimalPlayer) handleKey(k string) bool {
+	qp := mp.qoaPlayer
+	if mp.prompting {
+		mp.handlePromptKey(k)
+		return true
 	}
-	<-ready
+	switch {
+	case matchesKey(k, mp.keys.quit):
+		mp.printf("\nQuitting\n")
+		return false
+	case matchesKey(k, mp.keys.togglePlay):
+		qp.togglePlayPause()
+		mp.paused = !qp.player.IsPlaying()
+		if mp.paused {
+			mp.printf("\nPaused at %s\n", formatDuration(qp.position()))
+		} else {
+			mp.printf("\nResumed at %s\n", formatDuration(qp.position()))
+		}
+	case matchesKey(k, mp.keys.seekForward):
+		qp.seekForward()
+		mp.lastTick = time.Time{}
+	case matchesKey(k, mp.keys.seekBack):
+		qp.seekBack()
+		mp.lastTick = time.Time{}
+	case matchesKey(k, mp.keys.jump):
+		qp.seekToPercent(float64(k[0]-'0') / 10)
+		mp.lastTick = time.Time{}
+	case matchesKey(k, mp.keys.goTo):
+		mp.prompting, mp.typed = true, ""
+		mp.printf("\nGo to: ")
+	case matchesKey(k, mp.keys.nextSong):
+		if next, ok := mp.songs.order.next(false); ok {
+			mp.loadSong(next)
+		}
+	case matchesKey(k, mp.keys.previousSong):
+		if qp.restart() {
+			mp.lastTick = time.Time{}
+			mp.printf("\nRestarted\n")
+		} else {
+			mp.loadSong(mp.songs.order.previous())
+		}
+	case matchesKey(k, mp.keys.toggleShuffle):
+		mp.songs.order.setShuffle(!mp.songs.order.shuffle)
+		mp.queueNextSong()
+		mp.printf("\n%s\n", mp.songs.order.describe())
+	case matchesKey(k, mp.keys.cycleRepeat):
+		mp.songs.order.cycleRepeat()
+		mp.queueNextSong()
+		mp.printf("\n%s\n", mp.songs.order.describe())
+	case matchesKey(k, mp.keys.volumeUp):
+		mp.volumeChanged(qp.changeVolume(volumeStep))
+	case matchesKey(k, mp.keys.volumeDown):
+		mp.volumeChanged(qp.changeVolume(-volumeStep))
+	case matchesKey(k, mp.keys.mute):
+		mp.volumeChanged(qp.toggleMute())
+	case matchesKey(k, mp.keys.speedUp):
+		qp.changeSpeed(speedStep)
+		mp.printf("\nSpeed: %s\n", qp.settings.describeSpeed())
+	case matchesKey(k, mp.keys.speedDown):
+		qp.changeSpeed(-speedStep)
+		mp.printf("\nSpeed: %s\n", qp.settings.describeSpeed())
+	case matchesKey(k, mp.keys.toggleVarispeed):
+		qp.toggleVarispeed()
+		mp.printf("\nSpeed: %s\n", qp.settings.describeSpeed())
+	case matchesKey(k, mp.keys.setLoopStart):
+		qp.setLoopStart()
+		mp.printf("\nLoop: %s\n", qp.describeLoop())
+	case matchesKey(k, mp.keys.setLoopEnd):
+		qp.setLoopEnd()
+		mp.printf("\nLoop: %s\n", qp.describeLoop())
+	case matchesKey(k, mp.keys.toggleLoop):
+		qp.toggleLoop()
+		mp.lastTick = time.Time{}
+		mp.printf("\nLoop: %s\n", qp.describeLoop())
+	case matchesKey(k, mp.keys.savePlaylist):
+		if err := mp.songs.save(); err != nil {
+			mp.printf("\nError saving playlist: %v\n", err)
+		} else {
+			mp.printf("\nSaved playlist to %s\n", mp.songs.saveAs)
+		}
+	}
+	return true
+}
 
-	qp := newQOAPlayer(filename, ctx)
+// handlePromptKey handles a key typed at the go to prompt, which takes a time like 1:23.5.
+func (mp *minimalPlayer) handlePromptKey(k string) {
+	switch k {
+	case "enter":
+		mp.prompting = false
+		position, err := parseTimestamp(mp.typed)
+		if err != nil {
+			mp.printf("\n%v\n", err)
+			return
+		}
+		mp.qoaPlayer.seekTo(position)
+		mp.lastTick = time.Time{}
+		mp.printf("\n")
+	case "esc", "ctrl+c":
+		mp.prompting = false
+		mp.printf("\n")
+	case "backspace":
+		if mp.typed != "" {
+			mp.typed = mp.typed[:len(mp.typed)-1]
+			mp.printf("\b \b")
+		}
+	default:
+		if len(k) == 1 {
+			mp.typed += k
+			mp.printf("%s", k)
+		}
+	}
+}
 
-	fmt.Printf("\nPlaying: %s\n", filename)
-	fmt.Printf("Sample Rate: %d Hz, Channels: %d, Bitrate: %d kbps\n",
-		qp.qoaMetadata.SampleRate,
-		qp.qoaMetadata.Channels,
-		qp.bitrate,
-	)
-	fmt.Printf("Duration: %s\n", formatDuration(qp.totalLength))
-	fmt.Println("\nPress Ctrl+C to quit")
+// tick updates the time display and moves on when a song is over. It reports whether to keep playing.
+func (mp *minimalPlayer) tick() bool {
+	qp := mp.qoaPlayer
+	qp.getPlayerProgress()
+	if qp.track != mp.heard {
+		// The next song started playing right after the last
+		mp.heard = qp.track
+		mp.currentIndex = qp.index
+		mp.songs.order.moveTo(mp.currentIndex)
+		mp.lastTick = time.Time{}
+		mp.announce()
+		mp.queueNextSong()
+	}
+	if qp.ended() {
+		next, ok := mp.songs.order.next(true)
+		if !ok {
+			mp.printf("\nPlayback complete\n")
+			mp.stop()
+			return false
+		}
+		// The next song wasn't loaded in time
+		mp.loadSong(next)
+		return true
+	}
+
+	if mp.tty && !mp.paused && !mp.prompting && time.Since(mp.lastTick) >= time.Second {
+		fmt.Fprintf(mp.out, "\rTime: %s / %s ",
+			formatDuration(qp.position()),
+			formatDuration(qp.totalLength),
+		)
+		mp.lastTick = time.Now()
+	}
+	return true
+}
 
-	qp.player.Play()
+func (mp *minimalPlayer) loadSong(index int) {
+	mp.qoaPlayer.load(index, mp.filenames[index])
+	mp.qoaPlayer.player.Play()
+	mp.heard = mp.qoaPlayer.track
+	mp.currentIndex = index
+	mp.songs.order.moveTo(index)
+	mp.paused = false
+	mp.lastTick = time.Time{}
+	mp.announce()
+	mp.queueNextSong()
+}
+
+// queueNextSong gets the song after the current one ready to play without a gap.
+func (mp *minimalPlayer) queueNextSong() {
+	next, ok := mp.songs.order.next(true)
+	if !ok {
+		mp.qoaPlayer.queueNext(-1, "")
+		return
+	}
+	mp.qoaPlayer.queueNext(next, mp.filenames[next])
+}
 
-	return minimalModel{
-		player:    qp,
-		lastTick:  time.Now(),
-		isPlaying: true,
+func (mp *minimalPlayer) volumeChanged(err error) {
+	if err != nil {
+		mp.printf("\nError saving settings: %v\n", err)
 	}
+	mp.printf("\nVolume: %s\n", mp.qoaPlayer.settings.describeVolume())
 }
 
-func (m minimalModel) Init() tea.Cmd {
-	return tickCmd()
+func (mp *minimalPlayer) announce() {
+	qp := mp.qoaPlayer
+	mp.printf("\n[%d/%d] %s (%s, %d Hz, %d channels, %d kbps)\n",
+		mp.currentIndex+1, len(mp.filenames), mp.songs.title(mp.currentIndex),
+		formatDuration(qp.totalLength), qp.qoaMetadata.SampleRate, qp.qoaMetadata.Channels, qp.bitrate,
+	)
 }
 
-func (m minimalModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg.(type) {
-	case tickMsg:
-		if time.Since(m.lastTick) >= time.Second {
-			progress := m.player.getPlayerProgress()
-			currentDuration := time.Duration(m.player.currentSeconds * float64(time.Second))
-			fmt.Printf("\rTime: %s / %s",
-				formatDuration(currentDuration),
-				formatDuration(m.player.totalLength),
-			)
-			m.lastTick = time.Now()
+func (mp *minimalPlayer) stop() {
+	mp.qoaPlayer.player.Close()
+}
 
-			if progress >= 1.0 {
-				fmt.Println("\nPlayback complete")
-				return m, tea.Quit
-			}
+// printf prints a message. Messages start on a fresh line when the live time display is used.
+func (mp *minimalPlayer) printf(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	if !mp.tty {
+		msg = strings.TrimPrefix(msg, "\n")
+	}
+	if mp.raw {
+		msg = strings.ReplaceAll(msg, "\n", "\r\n")
+	}
+	fmt.Fprint(mp.out, msg)
+}
+
+func (mp *minimalPlayer) keyHelp() string {
+	controls := []struct {
+		binding key.Binding
+		desc    string
+	}{
+		{mp.keys.togglePlay, "play/pause"},
+		{mp.keys.seekBack, "seek back"},
+		{mp.keys.seekForward, "seek forward"},
+		{mp.keys.jump, "jump to 0-90%"},
+		{mp.keys.goTo, "go to time"},
+		{mp.keys.previousSong, "previous"},
+		{mp.keys.nextSong, "next"},
+		{mp.keys.toggleShuffle, "shuffle"},
+		{mp.keys.cycleRepeat, "repeat"},
+		{mp.keys.savePlaylist, "save playlist"},
+		{mp.keys.volumeUp, "volume up"},
+		{mp.keys.volumeDown, "volume down"},
+		{mp.keys.mute, "mute"},
+		{mp.keys.speedUp, "faster"},
+		{mp.keys.speedDown, "slower"},
+		{mp.keys.toggleVarispeed, "varispeed"},
+		{mp.keys.setLoopStart, "loop start"},
+		{mp.keys.setLoopEnd, "loop end"},
+		{mp.keys.toggleLoop, "loop"},
+		{mp.keys.quit, "quit"},
+	}
+	var help []string
+	for _, c := range controls {
+		keys := strings.ReplaceAll(strings.Join(c.binding.Keys(), "/"), " ", "space")
+		help = append(help, keys+" "+c.desc)
+	}
+	return strings.Join(help, ", ")
+}
+
+func matchesKey(k string, b key.Binding) bool {
+	for _, bk := range b.Keys() {
+		if k == bk {
+			return true
 		}
-		return m, tickCmd()
 	}
+	return false
+}
 
-	return m, nil
+// readKeys sends the keys read from r, named like bubbletea names them. The channel is
+// closed when r is.
+func readKeys(r io.Reader) <-chan string {
+	keys := make(chan string)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 16)
+		for {
+			n, err := r.Read(buf)
+			for _, k := range parseKeys(buf[:n]) {
+				keys <- k
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return keys
 }
 
-func (m minimalModel) View() string {
-	return ""
+// parseKeys names the keys in a chunk of terminal input.
+func parseKeys(input []byte) []string {
+	arrows := map[byte]string{'A': "up", 'B': "down", 'C': "right", 'D': "left"}
+	var keys []string
+	for i := 0; i < len(input); i++ {
+		switch b := input[i]; {
+		case b == 0x1b && i+2 < len(input) && input[i+1] == '[' && arrows[input[i+2]] != "":
+			keys = append(keys, arrows[input[i+2]])
+			i += 2
+		case b == 0x1b:
+			keys = append(keys, "esc")
+		case b == 0x03:
+			keys = append(keys, "ctrl+c")
+		case b == '\r' || b == '\n':
+			keys = append(keys, "enter")
+		case b == '\t':
+			keys = append(keys, "tab")
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, "backspace")
+		default:
+			keys = append(keys, string(rune(b)))
+		}
+	}
+	return keys
 }
@@ -0,0 +1,396 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync"
+)
+
+// sampleSource gives random access to the samples of a track.
+type sampleSource interface {
+	SampleRate() int
+	Channels() int
+	// Frames is the length of the track in sample frames.
+	Frames() int
+	// ReadFrames copies the interleaved samples of the frames from start into dst, returning
+	// the number of frames copied. It copies fewer than asked only at the end of the track.
+	ReadFrames(dst []int16, start int) (int, error)
+}
+
+// memorySource is a sampleSource of decoded samples.
+type memorySource struct {
+	samples    []int16
+	sampleRate int
+	channels   int
+}
+
+func (s *memorySource) SampleRate() int { return s.sampleRate }
+func (s *memorySource) Channels() int   { return s.channels }
+func (s *memorySource) Frames() int     { return len(s.samples) / s.channels }
+
+func (s *memorySource) ReadFrames(dst []int16, start int) (int, error) {
+	if start < 0 || start > s.Frames() {
+		return 0, errors.New("read out of range")
+	}
+	n := copy(dst, s.samples[start*s.channels:])
+	return n / s.channels, nil
+}
+
+// errLooped is returned by pcmStream.Read when it gets to the end of the loop. The next read
+// starts over from the start of it.
+var errLooped = errors.New("loop start")
+
+// sincTaps is the half width of the resampling filter, in source frames at full bandwidth.
+// Higher is sharper, and slower.
+const sincTaps = 8
+
+// streamWindowFrames is how many source frames pcmStream converts at a time.
+const streamWindowFrames = 4096
+
+// pcmStream reads a track as 16-bit little-endian PCM in an output's format, converting channels
+// and resampling as it goes. The output reads it on its own goroutine, so it is safe to ask for
+// the position while it plays.
+type pcmStream struct {
+	mu  sync.Mutex
+	src sampleSource
+	// sampleRate and channels are the output format
+	sampleRate int
+	channels   int
+	// ratio is the source frames per output frame
+	ratio float64
+	// cutoff is the filter cutoff relative to the source Nyquist frequency, below 1 when downsampling
+	cutoff float64
+	// matrix maps source channels to output channels, as matrix[out][in]
+	matrix [][]float64
+	// pos is the next output frame to read, and length the number of output frames. Both are
+	// at normal speed, so they keep track of the time in the track whatever the speed.
+	pos    int64
+	length int64
+	// speed is how fast the track plays. Unless varispeed is set, the pitch stays the same.
+	speed     float64
+	varispeed bool
+	// frac is how far past pos playing at speed has got, in frames
+	frac float64
+	// stretch changes the speed without changing the pitch
+	stretch *wsola
+	// loopStart and loopEnd are the region played over and over while looping is set, in output frames
+	loopStart, loopEnd int64
+	looping            bool
+
+	// window holds the channel converted source frames from windowStart
+	window      []float64
+	windowStart int
+	scratch     []int16
+	err         error
+}
+
+func newPCMStream(src sampleSource, sampleRate, channels int) *pcmStream {
+	s := &pcmStream{
+		src:        src,
+		sampleRate: sampleRate,
+		channels:   channels,
+		ratio:      float64(src.SampleRate()) / float64(sampleRate),
+		matrix:     channelMatrix(src.Channels(), channels),
+		speed:      1,
+		stretch:    newWSOLA(sampleRate, channels),
+	}
+	s.cutoff = min(1, 1/s.ratio)
+	s.length = int64(math.Ceil(float64(src.Frames()) / s.ratio))
+	s.loopEnd = s.length
+	return s
+}
+
+func (s *pcmStream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.looping && s.pos >= s.loopEnd {
+		s.pos, s.frac = s.loopStart, 0
+		s.stretch.reset()
+		return 0, errLooped
+	}
+	if s.pos >= s.length {
+		return 0, io.EOF
+	}
+
+	end := s.length
+	if s.looping {
+		end = s.loopEnd
+	}
+	frameSize := s.channels * 2
+	frames := 0
+	frame := make([]float64, s.channels)
+	for ; (frames+1)*frameSize <= len(p) && s.pos < end; frames++ {
+		if err := s.nextFrame(frame); err != nil {
+			s.err = err
+			return frames * frameSize, err
+		}
+		for c, v := range frame {
+			binary.LittleEndian.PutUint16(p[frames*frameSize+c*2:], uint16(clampInt16(int(math.Round(v)))))
+		}
+	}
+	return frames * frameSize, nil
+}
+
+// nextFrame computes the frame at the position played, and moves on by speed.
+func (s *pcmStream) nextFrame(out []float64) error {
+	var err error
+	switch {
+	case s.speed == 1:
+		err = s.resample(s.pos, out)
+	case s.varispeed:
+		// Reading the source faster raises the pitch, and needs filtering like downsampling does
+		err = s.resampleAt((float64(s.pos)+s.frac)*s.ratio, min(1, 1/(s.ratio*s.speed)), out)
+	default:
+		err = s.stretch.next(s, float64(s.pos)+s.frac, out)
+	}
+	if err != nil {
+		return err
+	}
+	s.frac += s.speed
+	whole := math.Floor(s.frac)
+	s.pos += int64(whole)
+	s.frac -= whole
+	return nil
+}
+
+// setSpeed changes how fast the track plays, from the next frame read.
+func (s *pcmStream) setSpeed(speed float64, varispeed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.speed, s.varispeed = speed, varispeed
+	s.stretch.reset()
+}
+
+// setLoop sets the region to loop, in output frames, and whether to loop it. The loop starts
+// over on the frame after the last one of the region, with no gap.
+func (s *pcmStream) setLoop(start, end int64, looping bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loopStart = max(0, min(start, s.length-1))
+	s.loopEnd = max(s.loopStart+1, min(end, s.length))
+	s.looping = looping
+}
+
+// loop returns the region to loop, and whether it's looping.
+func (s *pcmStream) loop() (start, end int64, looping bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loopStart, s.loopEnd, s.looping
+}
+
+// Seek seeks in bytes of the output stream, like any io.Seeker. Seeks out of range are clamped to the track.
+func (s *pcmStream) Seek(offset int64, whence int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frameSize := int64(s.channels * 2)
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset / frameSize
+	case io.SeekCurrent:
+		pos = s.pos + offset/frameSize
+	case io.SeekEnd:
+		pos = s.length + offset/frameSize
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	s.pos = max(0, min(pos, s.length))
+	s.frac = 0
+	s.stretch.reset()
+	return s.pos * frameSize, nil
+}
+
+// Position is the next output frame to be read.
+func (s *pcmStream) Position() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pos
+}
+
+// Length is the length of the track in output frames.
+func (s *pcmStream) Length() int64 {
+	return s.length
+}
+
+// resample computes output frame n, at normal speed.
+func (s *pcmStream) resample(n int64, out []float64) error {
+	if s.ratio == 1 {
+		clear(out)
+		i := int(n)
+		if err := s.load(i, i+1); err != nil {
+			return err
+		}
+		copy(out, s.window[(i-s.windowStart)*s.channels:])
+		return nil
+	}
+	return s.resampleAt(float64(n)*s.ratio, s.cutoff, out)
+}
+
+// resampleAt computes the frame at source position t, filtered with the given cutoff.
+func (s *pcmStream) resampleAt(t, cutoff float64, out []float64) error {
+	clear(out)
+	// A Lanczos windowed sinc, stretched when downsampling so it also filters out
+	// what the output rate can't hold
+	halfWidth := int(math.Ceil(sincTaps / cutoff))
+	center := int(math.Floor(t))
+	lo, hi := center-halfWidth+1, center+halfWidth+1
+	if err := s.load(lo, hi); err != nil {
+		return err
+	}
+	weights := 0.0
+	for i := lo; i < hi; i++ {
+		x := (t - float64(i)) * cutoff
+		w := lanczos(x, sincTaps)
+		if w == 0 {
+			continue
+		}
+		weights += w
+		frame := s.window[(i-s.windowStart)*s.channels:]
+		for c := range out {
+			out[c] += w * frame[c]
+		}
+	}
+	// Normalizing keeps a constant signal constant, whatever the phase
+	if weights != 0 {
+		for c := range out {
+			out[c] /= weights
+		}
+	}
+	return nil
+}
+
+// load makes sure the window holds the source frames from lo up to hi. Frames before the
+// start or past the end of the track are silent.
+func (s *pcmStream) load(lo, hi int) error {
+	if s.window != nil && lo >= s.windowStart && hi <= s.windowStart+len(s.window)/s.channels {
+		return nil
+	}
+
+	size := max(hi-lo, streamWindowFrames)
+	inChannels := s.src.Channels()
+	s.windowStart = lo
+	s.window = s.window[:0]
+	s.window = append(s.window, make([]float64, size*s.channels)...)
+	start, end := max(lo, 0), min(lo+size, s.src.Frames())
+	if start >= end {
+		return nil
+	}
+
+	if cap(s.scratch) < (end-start)*inChannels {
+		s.scratch = make([]int16, (end-start)*inChannels)
+	}
+	scratch := s.scratch[:(end-start)*inChannels]
+	n, err := s.src.ReadFrames(scratch, start)
+	if err != nil {
+		return err
+	}
+	for f := range n {
+		in := scratch[f*inChannels : (f+1)*inChannels]
+		out := s.window[(start-lo+f)*s.channels:]
+		for c, row := range s.matrix {
+			v := 0.0
+			for k, g := range row {
+				v += g * float64(in[k])
+			}
+			out[c] = v
+		}
+	}
+	return nil
+}
+
+func lanczos(x float64, a int) float64 {
+	if x == 0 {
+		return 1
+	}
+	if math.Abs(x) >= float64(a) {
+		return 0
+	}
+	px := math.Pi * x
+	return float64(a) * math.Sin(px) * math.Sin(px/float64(a)) / (px * px)
+}
+
+// Speaker positions in the QOA channel layouts
+const (
+	speakerLeft = iota
+	speakerRight
+	speakerCenter
+	speakerLFE
+	speakerBackLeft
+	speakerBackRight
+	speakerBackCenter
+	speakerSideLeft
+	speakerSideRight
+)
+
+// qoaChannelLayouts are the speaker positions of each channel count, as given by the QOA spec.
+var qoaChannelLayouts = map[int][]int{
+	1: {speakerCenter},
+	2: {speakerLeft, speakerRight},
+	3: {speakerLeft, speakerRight, speakerCenter},
+	4: {speakerLeft, speakerRight, speakerBackLeft, speakerBackRight},
+	5: {speakerLeft, speakerRight, speakerCenter, speakerBackLeft, speakerBackRight},
+	6: {speakerLeft, speakerRight, speakerCenter, speakerLFE, speakerBackLeft, speakerBackRight},
+	7: {speakerLeft, speakerRight, speakerCenter, speakerLFE, speakerBackCenter, speakerSideLeft, speakerSideRight},
+	8: {speakerLeft, speakerRight, speakerCenter, speakerLFE, speakerBackLeft, speakerBackRight, speakerSideLeft, speakerSideRight},
+}
+
+// stereoGains is how loud each speaker position is in the left and right of a stereo downmix.
+// The LFE channel is dropped, as is usual.
+var stereoGains = map[int][2]float64{
+	speakerLeft:       {1, 0},
+	speakerRight:      {0, 1},
+	speakerCenter:     {math.Sqrt2 / 2, math.Sqrt2 / 2},
+	speakerLFE:        {0, 0},
+	speakerBackLeft:   {math.Sqrt2 / 2, 0},
+	speakerBackRight:  {0, math.Sqrt2 / 2},
+	speakerBackCenter: {0.5, 0.5},
+	speakerSideLeft:   {math.Sqrt2 / 2, 0},
+	speakerSideRight:  {0, math.Sqrt2 / 2},
+}
+
+// channelMatrix returns the gains that mix from channels into to channels, as matrix[out][in].
+// Mono is copied to every output channel, and more channels than the output has are mixed
+// down to stereo by their speaker positions, then to mono if need be.
+func channelMatrix(from, to int) [][]float64 {
+	matrix := make([][]float64, to)
+	for i := range matrix {
+		matrix[i] = make([]float64, from)
+	}
+	switch {
+	case from == to:
+		for i := range matrix {
+			matrix[i][i] = 1
+		}
+	case from == 1:
+		for i := range matrix {
+			matrix[i][0] = 1
+		}
+	default:
+		var stereo [2][]float64
+		for side := range stereo {
+			stereo[side] = make([]float64, from)
+			sum := 0.0
+			for in, speaker := range qoaChannelLayouts[from] {
+				stereo[side][in] = stereoGains[speaker][side]
+				sum += stereo[side][in]
+			}
+			// Scale so a full scale signal in every channel can't clip
+			for in := range stereo[side] {
+				stereo[side][in] /= sum
+			}
+		}
+		if to == 2 {
+			matrix[0], matrix[1] = stereo[0], stereo[1]
+			break
+		}
+		for in := range from {
+			matrix[0][in] = (stereo[0][in] + stereo[1][in]) / 2
+		}
+	}
+	return matrix
+}
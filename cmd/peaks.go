@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var peaksCmd = &cobra.Command{
+	Use:   "peaks <file>",
+	Short: "Export waveform peak data for web players",
+	Long: `Export the min/max peaks of every channel for each pixel of a waveform display.
+
+The output uses the version 2 .dat and JSON formats of BBC audiowaveform, so it can be
+used directly by peaks.js and other players that read them.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		inputFile := args[0]
+		pixelsPerSecond, _ := cmd.Flags().GetInt("pixels-per-second")
+		format, _ := cmd.Flags().GetString("format")
+		bits, _ := cmd.Flags().GetInt("bits")
+		outputFile, _ := cmd.Flags().GetString("output")
+
+		if format != "json" && format != "dat" {
+			logger.Fatalf("Unknown format %q, expected json or dat", format)
+		}
+		if bits != 8 && bits != 16 {
+			logger.Fatalf("Unsupported bits %d, expected 8 or 16", bits)
+		}
+		if pixelsPerSecond <= 0 {
+			logger.Fatal("--pixels-per-second must be positive")
+		}
+
+		if outputFile == "-" && !quiet {
+			// Keep log messages out of the peak data
+			logger.SetOutput(os.Stderr)
+		}
+
+		decodedData, q, err := decodeAudio(inputFile)
+		if err != nil {
+			logger.Fatalf("Error decoding %s: %v", inputFile, err)
+		}
+
+		p := computePeaks(decodedData, int(q.Channels), int(q.SampleRate), max(int(q.SampleRate)/pixelsPerSecond, 1), bits)
+
+		if outputFile == "" {
+			outputFile = strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile)) + "." + format
+		}
+		var w io.Writer = os.Stdout
+		if outputFile != "-" {
+			f, err := os.Create(outputFile)
+			if err != nil {
+				logger.Fatalf("Error creating %s: %v", outputFile, err)
+			}
+			defer f.Close()
+			w = f
+		}
+
+		if format == "json" {
+			err = p.writeJSON(w)
+		} else {
+			err = p.writeDat(w)
+		}
+		if err != nil {
+			logger.Fatalf("Error writing peaks: %v", err)
+		}
+		logger.Debug(outputFile, "pixels", p.length, "samples/pixel", p.samplesPerPixel, "bits", p.bits)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(peaksCmd)
+	peaksCmd.Flags().Int("pixels-per-second", 100, "Waveform resolution, in pixels per second of audio")
+	peaksCmd.Flags().String("format", "json", "Output format: json or dat")
+	peaksCmd.Flags().Int("bits", 16, "Resolution of the peak values: 8 or 16")
+	peaksCmd.Flags().StringP("output", "o", "", "Output file, - for stdout (default <file>.<format>)")
+}
+
+// peaks is waveform peak data as produced by audiowaveform.
+type peaks struct {
+	channels        int
+	sampleRate      int
+	samplesPerPixel int
+	bits            int
+	// length is the number of pixels
+	length int
+	// data holds min, max pairs for every channel, for every pixel
+	data []int16
+}
+
+// computePeaks finds the min and max of every channel in each samplesPerPixel sized bucket.
+func computePeaks(decodedData []int16, channels, sampleRate, samplesPerPixel, bits int) *peaks {
+	totalSamples := len(decodedData) / channels
+	length := (totalSamples + samplesPerPixel - 1) / samplesPerPixel
+	p := &peaks{
+		channels:        channels,
+		sampleRate:      sampleRate,
+		samplesPerPixel: samplesPerPixel,
+		bits:            bits,
+		length:          length,
+		data:            make([]int16, 0, length*channels*2),
+	}
+
+	for pixel := 0; pixel < length; pixel++ {
+		start := pixel * samplesPerPixel
+		end := min(start+samplesPerPixel, totalSamples)
+		for c := 0; c < channels; c++ {
+			lo, hi := decodedData[start*channels+c], decodedData[start*channels+c]
+			for i := start + 1; i < end; i++ {
+				v := decodedData[i*channels+c]
+				lo, hi = min(lo, v), max(hi, v)
+			}
+			if bits == 8 {
+				lo, hi = lo>>8, hi>>8
+			}
+			p.data = append(p.data, lo, hi)
+		}
+	}
+	return p
+}
+
+// writeJSON writes the audiowaveform JSON format.
+func (p *peaks) writeJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(struct {
+		Version         int     `json:"version"`
+		Channels        int     `json:"channels"`
+		SampleRate      int     `json:"sample_rate"`
+		SamplesPerPixel int     `json:"samples_per_pixel"`
+		Bits            int     `json:"bits"`
+		Length          int     `json:"length"`
+		Data            []int16 `json:"data"`
+	}{2, p.channels, p.sampleRate, p.samplesPerPixel, p.bits, p.length, p.data})
+}
+
+// writeDat writes the little-endian audiowaveform binary format.
+func (p *peaks) writeDat(w io.Writer) error {
+	flags := uint32(0)
+	if p.bits == 8 {
+		flags = 1
+	}
+	header := []any{int32(2), flags, int32(p.sampleRate), int32(p.samplesPerPixel), uint32(p.length), int32(p.channels)}
+	for _, v := range header {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	if p.bits == 16 {
+		return binary.Write(w, binary.LittleEndian, p.data)
+	}
+	data := make([]int8, len(p.data))
+	for i, v := range p.data {
+		data[i] = int8(v)
+	}
+	return binary.Write(w, binary.LittleEndian, data)
+}
@@ -4,15 +4,16 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/braheezy/qoa"
 	"github.com/spf13/cobra"
 )
 
 var playCmd = &cobra.Command{
-	Use:   "play [<file/directories>]",
+	Use:   "play [<file/directories/playlists>]",
 	Short: "Play .qoa audio file(s)",
-	Long:  "Provide one or more QOA files to play. If none are provided, the current directory is tried by default.",
+	Long:  "Provide one or more QOA files, directories of them, or .m3u/.m3u8/.pls playlists to play. If none are provided, the current directory is tried by default.",
 	Args:  cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Use current directory if no arguments are provided
@@ -20,9 +21,27 @@ var playCmd = &cobra.Command{
 			args = append(args, ".")
 		}
 
-		// Input is one or more files or directories. Find all QOA files, recursively.
+		// Input is one or more files, directories or playlists. Find all QOA files, recursively.
 		var allFiles []string
+		// titles are the titles given by playlists, in step with allFiles
+		var titles []string
 		for _, arg := range args {
+			if isPlaylistFile(arg) {
+				entries, err := readPlaylist(arg)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error reading playlist %s: %v\n", arg, err)
+					continue
+				}
+				for _, e := range entries {
+					if _, err := qoa.IsValidQOAFile(e.path); err != nil {
+						fmt.Fprintf(os.Stderr, "Error checking file %s: %v\n", e.path, err)
+						continue
+					}
+					allFiles = append(allFiles, e.path)
+					titles = append(titles, e.title)
+				}
+				continue
+			}
 			info, err := os.Stat(arg)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Error accessing %s: %v\n", arg, err)
@@ -35,6 +54,7 @@ var playCmd = &cobra.Command{
 					continue
 				}
 				allFiles = append(allFiles, files...)
+				titles = append(titles, make([]string, len(files))...)
 			} else {
 				valid, err := qoa.IsValidQOAFile(arg)
 				if err != nil {
@@ -43,6 +63,7 @@ var playCmd = &cobra.Command{
 				}
 				if valid {
 					allFiles = append(allFiles, arg)
+					titles = append(titles, "")
 				}
 			}
 		}
@@ -52,14 +73,115 @@ var playCmd = &cobra.Command{
 		}
 
 		noTUI, _ := cmd.Flags().GetBool("no-tui")
+		outputName, _ := cmd.Flags().GetString("output")
+		outputSpeed, _ := cmd.Flags().GetFloat64("output-speed")
+		outputRate, _ := cmd.Flags().GetInt("output-rate")
+		shuffle, _ := cmd.Flags().GetBool("shuffle")
+		playlistFile, _ := cmd.Flags().GetString("save-playlist")
+		volume, _ := cmd.Flags().GetFloat64("volume")
+		repeatName, _ := cmd.Flags().GetString("repeat")
+		seekForward, _ := cmd.Flags().GetDuration("seek-forward")
+		seekBack, _ := cmd.Flags().GetDuration("seek-back")
+		speed, _ := cmd.Flags().GetFloat64("speed")
+		varispeed, _ := cmd.Flags().GetBool("varispeed")
+		loopStart, _ := cmd.Flags().GetString("loop-start")
+		loopEnd, _ := cmd.Flags().GetString("loop-end")
+		if noTUI && !cmd.Flags().Changed("repeat") {
+			// Without the TUI, the playlist plays through once unless asked otherwise
+			repeatName = "off"
+		}
+		repeat, err := parseRepeatMode(repeatName)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if outputRate < 0 {
+			logger.Fatalf("Invalid output rate: %d", outputRate)
+		}
+
+		// The output format is fixed, and every song is converted to it as it plays
+		sampleRate, channels, err := sessionFormat(allFiles, outputRate)
+		if err != nil {
+			logger.Fatalf("Error decoding QOA header: %v", err)
+		}
+		output, err := newAudioOutput(outputName, sampleRate, channels, outputSpeed)
+		if err != nil {
+			logger.Fatalf("Error opening output: %v", err)
+		}
+		defer func() {
+			if err := output.Close(); err != nil {
+				logger.Errorf("Error closing output: %v", err)
+			}
+		}()
+
+		// The volume is kept between sessions
+		settings := defaultPlayerSettings()
+		if path, err := playerSettingsPath(); err == nil {
+			settings, err = loadPlayerSettings(path)
+			if err != nil {
+				logger.Warnf("Error loading player settings: %v", err)
+			}
+		}
+		if cmd.Flags().Changed("volume") {
+			if volume < 0 || volume > 100 {
+				logger.Fatalf("Invalid volume: %v, expected 0 to 100", volume)
+			}
+			settings.Volume, settings.Muted = volume/100, false
+		}
+		if seekForward <= 0 || seekBack <= 0 {
+			logger.Fatalf("Invalid seek step, expected a positive duration like 10s")
+		}
+		settings.seekForward, settings.seekBack = seekForward, seekBack
+		if speed < minSpeed || speed > maxSpeed {
+			logger.Fatalf("Invalid speed: %v, expected %v to %v", speed, minSpeed, maxSpeed)
+		}
+		settings.speed, settings.varispeed = speed, varispeed
+		if loopStart != "" || loopEnd != "" {
+			settings.loop = true
+			if loopStart != "" {
+				if settings.loopStart, err = parseTimestamp(loopStart); err != nil {
+					logger.Fatalf("Invalid --loop-start: %v", err)
+				}
+			}
+			if loopEnd != "" {
+				if settings.loopEnd, err = parseTimestamp(loopEnd); err != nil {
+					logger.Fatalf("Invalid --loop-end: %v", err)
+				}
+				if settings.loopEnd <= settings.loopStart {
+					logger.Fatalf("--loop-end must be after --loop-start")
+				}
+			}
+		}
+
+		songs := &playlist{filenames: allFiles, titles: titles, order: newPlayOrder(len(allFiles), shuffle, repeat), saveAs: playlistFile}
 		if noTUI {
-			startMinimalPlayer(allFiles[0]) // Only play first file in minimal mode
+			startMinimalPlayer(songs, output, settings)
 		} else {
-			startTUI(allFiles)
+			startTUI(songs, output, settings)
 		}
 	},
 }
 
+// sessionFormat picks the output format for a playlist. The sample rate is outputRate, or the
+// first file's if that is 0. The output is stereo if any file has more than one channel, since
+// that is the most oto supports.
+func sessionFormat(filenames []string, outputRate int) (sampleRate, channels int, err error) {
+	channels = 1
+	for i, filename := range filenames {
+		qoaMetadata, err := readQOAHeader(filename)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", filename, err)
+		}
+		if i == 0 {
+			sampleRate = int(qoaMetadata.SampleRate)
+		}
+		channels = max(channels, min(int(qoaMetadata.Channels), 2))
+	}
+	if outputRate != 0 {
+		sampleRate = outputRate
+	}
+	return sampleRate, channels, nil
+}
+
 // Recursive function to find all valid QOA files
 func findAllQOAFiles(root string) ([]string, error) {
 	var files []string
@@ -80,4 +202,17 @@ func findAllQOAFiles(root string) ([]string, error) {
 func init() {
 	rootCmd.AddCommand(playCmd)
 	playCmd.Flags().BoolP("no-tui", "n", false, "Play audio without the TUI interface")
+	playCmd.Flags().StringP("output", "o", "device", "Where to play to: "+audioOutputs)
+	playCmd.Flags().Bool("shuffle", false, "Play the songs in random order, each once before any repeats")
+	playCmd.Flags().String("repeat", "all", "Repeat mode: off, all, or one (off by default with --no-tui)")
+	playCmd.Flags().String("save-playlist", "playlist.m3u", "Where the save playlist key writes the play order to")
+	playCmd.Flags().Float64("volume", 100, "Volume to start at, from 0 to 100. Defaults to the volume last used")
+	playCmd.Flags().Duration("seek-forward", 5*time.Second, "How far the seek forward key moves")
+	playCmd.Flags().Duration("seek-back", 7*time.Second, "How far the seek back key moves")
+	playCmd.Flags().Float64("speed", 1, "Playback speed, from 0.5 to 2. The pitch stays the same unless --varispeed is set")
+	playCmd.Flags().Bool("varispeed", false, "Change the pitch with the speed, like a tape")
+	playCmd.Flags().String("loop-start", "", "Loop the first song from this time, like 1:23.5")
+	playCmd.Flags().String("loop-end", "", "Loop the first song up to this time, like 1:30. Defaults to the end of the song")
+	playCmd.Flags().Int("output-rate", 0, "Sample rate to play at, 0 for the first file's. Songs at other rates are resampled")
+	playCmd.Flags().Float64("output-speed", 1, "Speed of the null and .wav outputs as a multiple of real time, 0 for as fast as possible")
 }
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// repeatMode is what happens at the end of a song, or of the playlist.
+type repeatMode int
+
+const (
+	// repeatOff stops at the end of the playlist
+	repeatOff repeatMode = iota
+	// repeatAll starts the playlist over
+	repeatAll
+	// repeatOne plays the same song again
+	repeatOne
+)
+
+var repeatModes = []string{"off", "all", "one"}
+
+func (r repeatMode) String() string {
+	return repeatModes[r]
+}
+
+func parseRepeatMode(s string) (repeatMode, error) {
+	for i, name := range repeatModes {
+		if s == name {
+			return repeatMode(i), nil
+		}
+	}
+	return repeatOff, fmt.Errorf("unknown repeat mode %q, expected off, all or one", s)
+}
+
+// playOrder is the order songs of a playlist play in. Shuffled, every song plays once before
+// any plays again.
+type playOrder struct {
+	shuffle bool
+	repeat  repeatMode
+	// order is the playlist indexes of this time through the playlist
+	order []int
+	// pos is the position in order of the song playing
+	pos int
+	// nextOrder is the order of the next time through, once a shuffled one has been needed
+	nextOrder []int
+	rng       *rand.Rand
+}
+
+func newPlayOrder(count int, shuffle bool, repeat repeatMode) *playOrder {
+	o := &playOrder{
+		repeat: repeat,
+		order:  make([]int, count),
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for i := range o.order {
+		o.order[i] = i
+	}
+	if shuffle {
+		// Any song can be first
+		o.shuffle = true
+		o.order = o.shuffled(-1)
+	}
+	return o
+}
+
+// current is the playlist index of the song playing, or first to play.
+func (o *playOrder) current() int {
+	return o.order[o.pos]
+}
+
+// setShuffle turns shuffle on or off, keeping the current song where it is.
+func (o *playOrder) setShuffle(shuffle bool) {
+	current := o.current()
+	o.shuffle = shuffle
+	o.nextOrder = nil
+	if !shuffle {
+		for i := range o.order {
+			o.order[i] = i
+		}
+		o.pos = current
+		return
+	}
+	o.order = o.shuffled(current)
+	o.pos = 0
+}
+
+// cycleRepeat moves on to the next repeat mode.
+func (o *playOrder) cycleRepeat() {
+	o.repeat = (o.repeat + 1) % repeatMode(len(repeatModes))
+}
+
+// shuffled returns a random order of the playlist that starts with first, if it isn't -1.
+func (o *playOrder) shuffled(first int) []int {
+	order := o.rng.Perm(len(o.order))
+	for i, index := range order {
+		if index == first {
+			order[0], order[i] = order[i], order[0]
+			break
+		}
+	}
+	return order
+}
+
+// next returns the playlist index of the song to play after the current one, and false at the
+// end of the playlist. auto is set when the song ended on its own, rather than being skipped,
+// which is when a song repeats.
+func (o *playOrder) next(auto bool) (int, bool) {
+	switch {
+	case auto && o.repeat == repeatOne:
+		return o.current(), true
+	case o.pos+1 < len(o.order):
+		return o.order[o.pos+1], true
+	case o.repeat == repeatOff:
+		return 0, false
+	case !o.shuffle:
+		return 0, true
+	}
+	if o.nextOrder == nil {
+		o.nextOrder = o.shuffled(-1)
+		// Don't play the last song twice in a row, when there's a choice
+		if o.nextOrder[0] == o.current() && len(o.nextOrder) > 1 {
+			o.nextOrder[0], o.nextOrder[1] = o.nextOrder[1], o.nextOrder[0]
+		}
+	}
+	return o.nextOrder[0], true
+}
+
+// previous returns the playlist index of the song played before the current one.
+func (o *playOrder) previous() int {
+	return o.order[max(o.pos-1, 0)]
+}
+
+// moveTo records that the song at playlist index is playing.
+func (o *playOrder) moveTo(index int) {
+	switch {
+	case index == o.current():
+	case o.pos+1 < len(o.order) && o.order[o.pos+1] == index:
+		o.pos++
+	case o.pos+1 == len(o.order) && o.nextOrder != nil && o.nextOrder[0] == index:
+		o.order, o.nextOrder, o.pos = o.nextOrder, nil, 0
+	default:
+		// A song picked out of order
+		for i, v := range o.order {
+			if v == index {
+				o.pos = i
+			}
+		}
+	}
+}
+
+// describe returns the modes, for showing to the user.
+func (o *playOrder) describe() string {
+	shuffle := "off"
+	if o.shuffle {
+		shuffle = "on"
+	}
+	return fmt.Sprintf("shuffle: %s | repeat: %s", shuffle, o.repeat)
+}
@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/braheezy/qoa"
+)
+
+// track is a song decoded and converted for an output, ready to play.
+type track struct {
+	// index is the position of the song in the playlist
+	index    int
+	filename string
+	// qoaMetadata is the QOA encoder struct.
+	qoaMetadata qoa.QOA
+	// totalLength is the total length of the song.
+	totalLength time.Duration
+	bitrate     uint32
+	// stream converts the song to the output format.
+	stream *pcmStream
+	// file is the open QOA file, which is decoded as it plays
+	file *os.File
+}
+
+// loadTrack opens a song to play to output. Only the header is read, so it is quick however
+// long the song is.
+func loadTrack(index int, filename string, output audioOutput) (*track, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading QOA file: %w", err)
+	}
+	source, err := newQOAStreamSource(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("decoding QOA data: %w", err)
+	}
+
+	// Songs are converted to the output format as they play, so any mix of sample rates
+	// and channel counts can share one output
+	qoaMetadata := source.q
+	return &track{
+		index:       index,
+		filename:    filename,
+		qoaMetadata: qoaMetadata,
+		totalLength: calcSongLength(&qoaMetadata),
+		bitrate:     (qoaMetadata.SampleRate * qoaMetadata.Channels * 16) / 1000,
+		stream:      newPCMStream(source, output.SampleRate(), output.ChannelCount()),
+		file:        file,
+	}, nil
+}
+
+func (t *track) close() {
+	t.file.Close()
+}
+
+// trackQueue plays tracks back to back through one player. The next track starts on the
+// sample after the last one ends, with no gap. Since the player reads ahead of what is heard,
+// it keeps a log of where each track started in the output, to work out which one is heard.
+type trackQueue struct {
+	mu        sync.Mutex
+	frameSize int
+	current   *track
+	next      *track
+	// generation counts the changes to what is up next, so stale loads can be dropped.
+	generation int
+	// read is the number of output frames read so far
+	read int64
+	// starts is the log of the tracks read from, oldest first
+	starts []trackStart
+	// recent holds the last recentFrames frames read, for showing what is heard
+	recent []int16
+	// speed is how fast the tracks play, and varispeed whether that changes their pitch
+	speed     float64
+	varispeed bool
+}
+
+// recentFrames is how many frames the queue keeps of what it read. It has to cover what the
+// player buffers, as well as what is shown.
+const recentFrames = 1 << 16
+
+// trackStart records that output frame at is frame offset of the track, and from there the
+// track played at speed.
+type trackStart struct {
+	track      *track
+	at, offset int64
+	speed      float64
+}
+
+func newTrackQueue(channels int) *trackQueue {
+	return &trackQueue{frameSize: channels * 2, recent: make([]int16, recentFrames*channels), speed: 1}
+}
+
+func (q *trackQueue) Read(p []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.current == nil {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n+q.frameSize <= len(p) {
+		m, err := q.current.stream.Read(p[n:])
+		n += m
+		q.read += int64(m / q.frameSize)
+		if err == io.EOF {
+			if q.next == nil {
+				break
+			}
+			q.current, q.next = q.next, nil
+			q.generation++
+			q.current.stream.Seek(0, io.SeekStart)
+			q.starts = append(q.starts, trackStart{track: q.current, at: q.read, speed: q.speed})
+			continue
+		}
+		if err == errLooped {
+			// The loop started over, so what's heard jumps back with it
+			q.starts = append(q.starts, trackStart{track: q.current, at: q.read, offset: q.current.stream.Position(), speed: q.speed})
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	q.remember(p[:n])
+	return n, nil
+}
+
+// remember keeps the frames in p, the last ones read, in recent.
+func (q *trackQueue) remember(p []byte) {
+	channels := q.frameSize / 2
+	frames := len(p) / q.frameSize
+	start := q.read - int64(frames)
+	for i := max(0, frames-recentFrames); i < frames; i++ {
+		at := int((start+int64(i))%recentFrames) * channels
+		for c := range channels {
+			q.recent[at+c] = int16(binary.LittleEndian.Uint16(p[(i*channels+c)*2:]))
+		}
+	}
+}
+
+// heardSamples fills dst with the last frames heard, given the bytes buffered by the player,
+// and returns the number of frames.
+func (q *trackQueue) heardSamples(buffered int, dst []int16) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	channels := q.frameSize / 2
+	end := q.read - int64(buffered/q.frameSize)
+	frames := min(int64(len(dst)/channels), end, recentFrames-(q.read-end))
+	for i := int64(0); i < frames; i++ {
+		at := int((end-frames+i)%recentFrames) * channels
+		copy(dst[i*int64(channels):], q.recent[at:at+channels])
+	}
+	return int(max(frames, 0))
+}
+
+// Seek seeks in the current track, in bytes of the output stream.
+func (q *trackQueue) Seek(offset int64, whence int) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.current == nil {
+		return 0, io.EOF
+	}
+	pos, err := q.current.stream.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	dropped := q.starts
+	q.starts = []trackStart{{track: q.current, at: q.read, offset: pos / int64(q.frameSize), speed: q.speed}}
+	for _, s := range dropped {
+		q.release(s.track)
+	}
+	return pos, nil
+}
+
+// play makes t the current track, from the start, with nothing up next.
+func (q *trackQueue) play(t *track) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t.stream.Seek(0, io.SeekStart)
+	t.stream.setSpeed(q.speed, q.varispeed)
+	dropped := []*track{q.current, q.next}
+	for _, s := range q.starts {
+		dropped = append(dropped, s.track)
+	}
+	q.current, q.next = t, nil
+	q.generation++
+	q.starts = []trackStart{{track: t, at: q.read, speed: q.speed}}
+	q.release(dropped...)
+}
+
+// rewind makes the current track t again, if the player read on past it. It is called before
+// seeking in t, which drops what the player has buffered.
+func (q *trackQueue) rewind(t *track) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.current == t {
+		return
+	}
+	for i, s := range q.starts {
+		if s.track == t {
+			// The track after t is already loaded, so it's up next again
+			dropped := q.next
+			for _, later := range q.starts[i+1:] {
+				if later.track != t {
+					q.next = later.track
+					q.next.stream.Seek(0, io.SeekStart)
+					break
+				}
+			}
+			q.current = t
+			q.generation++
+			q.release(dropped)
+			return
+		}
+	}
+}
+
+// queue returns the generation that setNext needs to queue a track after the current one.
+// Queuing clears whatever was up next.
+func (q *trackQueue) queue() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dropped := q.next
+	q.next = nil
+	q.generation++
+	q.release(dropped)
+	return q.generation
+}
+
+// setNext puts t up next, unless what is playing changed since queue returned generation.
+func (q *trackQueue) setNext(t *track, generation int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if generation != q.generation {
+		t.close()
+		return false
+	}
+	t.stream.setSpeed(q.speed, q.varispeed)
+	dropped := q.next
+	q.next = t
+	q.release(dropped)
+	return true
+}
+
+// setSpeed changes how fast the tracks play, from what is read next. What the player has
+// buffered plays out at the old speed.
+func (q *trackQueue) setSpeed(speed float64, varispeed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.speed, q.varispeed = speed, varispeed
+	for _, t := range []*track{q.current, q.next} {
+		if t != nil {
+			t.stream.setSpeed(speed, varispeed)
+		}
+	}
+	if q.current != nil {
+		q.starts = append(q.starts, trackStart{track: q.current, at: q.read, offset: q.current.stream.Position(), speed: speed})
+	}
+}
+
+// heard returns the track being heard, and the frame of it, given the bytes buffered by the player.
+func (q *trackQueue) heard(buffered int) (*track, int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.starts) == 0 {
+		return q.current, 0
+	}
+	frame := q.read - int64(buffered/q.frameSize)
+	i := len(q.starts) - 1
+	for i > 0 && q.starts[i].at > frame {
+		i--
+	}
+	// Tracks before the one heard can be forgotten
+	dropped := q.starts[:i]
+	q.starts = q.starts[i:]
+	for _, s := range dropped {
+		q.release(s.track)
+	}
+	s := q.starts[0]
+	return s.track, s.offset + int64(math.Round(float64(max(frame-s.at, 0))*s.speed))
+}
+
+// release closes the tracks that can't be played any more. It is called with the queue locked.
+func (q *trackQueue) release(tracks ...*track) {
+	for _, t := range tracks {
+		if t == nil || t == q.current || t == q.next || slices.ContainsFunc(q.starts, func(s trackStart) bool { return s.track == t }) {
+			continue
+		}
+		t.close()
+	}
+}
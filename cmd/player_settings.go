@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// playerSettings are the player settings kept between sessions.
+type playerSettings struct {
+	// Volume is the playback level, from 0 to 1
+	Volume float64 `json:"volume"`
+	Muted  bool    `json:"muted"`
+	// path is the file the settings are saved to. They aren't saved if it's empty.
+	path string
+	// seekForward and seekBack are how far the seek keys move, set for each session
+	seekForward, seekBack time.Duration
+	// speed is how fast songs play, set for each session. Unless varispeed is set, the pitch
+	// stays the same.
+	speed     float64
+	varispeed bool
+	// loop is set to loop the first song from loopStart to loopEnd, or its end if that's 0
+	loop               bool
+	loopStart, loopEnd time.Duration
+}
+
+const (
+	// volumeStep is how much the volume keys change the volume by.
+	volumeStep = 0.05
+	// speedStep is how much the speed keys change the speed by, between minSpeed and maxSpeed.
+	speedStep = 0.1
+	minSpeed  = 0.5
+	maxSpeed  = 2.0
+)
+
+func defaultPlayerSettings() *playerSettings {
+	return &playerSettings{Volume: 1, seekForward: 5 * time.Second, seekBack: 7 * time.Second, speed: 1}
+}
+
+// playerSettingsPath is where the settings are kept, in the user's config directory.
+func playerSettingsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "goqoa", "player.json"), nil
+}
+
+// loadPlayerSettings reads the settings saved at path. If there are none yet, they're the defaults.
+func loadPlayerSettings(path string) (*playerSettings, error) {
+	s := defaultPlayerSettings()
+	s.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return s, fmt.Errorf("%s: %w", path, err)
+	}
+	s.Volume = clampVolume(s.Volume)
+	return s, nil
+}
+
+func (s *playerSettings) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	temp := s.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, s.path)
+}
+
+// gain is the volume to play at, taking mute into account.
+func (s *playerSettings) gain() float64 {
+	if s.Muted {
+		return 0
+	}
+	return s.Volume
+}
+
+// describeVolume returns the volume, for showing to the user.
+func (s *playerSettings) describeVolume() string {
+	if s.Muted {
+		return "muted"
+	}
+	return fmt.Sprintf("%.0f%%", s.Volume*100)
+}
+
+// describeSpeed returns the speed, for showing to the user.
+func (s *playerSettings) describeSpeed() string {
+	if s.varispeed {
+		return fmt.Sprintf("%.1fx varispeed", s.speed)
+	}
+	return fmt.Sprintf("%.1fx", s.speed)
+}
+
+func clampVolume(v float64) float64 {
+	return max(0, min(1, v))
+}
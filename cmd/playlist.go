@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// playlistEntry is a song listed in a playlist file.
+type playlistEntry struct {
+	path string
+	// title is the title given by the playlist, if any
+	title string
+}
+
+func isPlaylistFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".m3u", ".m3u8", ".pls":
+		return true
+	}
+	return false
+}
+
+// readPlaylist reads an M3U or PLS playlist. Relative paths are resolved against the
+// playlist's directory.
+func readPlaylist(filename string) ([]playlistEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []playlistEntry
+	if strings.ToLower(filepath.Ext(filename)) == ".pls" {
+		entries, err = parsePLS(f)
+	} else {
+		entries, err = parseM3U(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	dir := filepath.Dir(filename)
+	for i, e := range entries {
+		e.path = filepath.FromSlash(e.path)
+		if !filepath.IsAbs(e.path) {
+			e.path = filepath.Join(dir, e.path)
+		}
+		entries[i] = e
+	}
+	return entries, nil
+}
+
+// parseM3U reads a plain or extended M3U playlist. #EXTINF titles apply to the path after them.
+func parseM3U(r io.Reader) ([]playlistEntry, error) {
+	var entries []playlistEntry
+	title := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// #EXTINF:<seconds>,<title>
+			if _, t, ok := strings.Cut(line, ","); ok {
+				title = strings.TrimSpace(t)
+			}
+		case strings.HasPrefix(line, "#"):
+		case strings.Contains(line, "://"):
+			logger.Warnf("Skipping playlist entry %s, only local files can be played", line)
+			title = ""
+		default:
+			entries = append(entries, playlistEntry{path: line, title: title})
+			title = ""
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// parsePLS reads a PLS playlist, made of numbered FileN and TitleN keys.
+func parsePLS(r io.Reader) ([]playlistEntry, error) {
+	files := map[int]string{}
+	titles := map[int]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		var target map[int]string
+		var number string
+		switch {
+		case strings.HasPrefix(key, "File"):
+			target, number = files, strings.TrimPrefix(key, "File")
+		case strings.HasPrefix(key, "Title"):
+			target, number = titles, strings.TrimPrefix(key, "Title")
+		default:
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q", key)
+		}
+		target[n] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no File entries found")
+	}
+
+	numbers := make([]int, 0, len(files))
+	for n := range files {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	var entries []playlistEntry
+	for _, n := range numbers {
+		if strings.Contains(files[n], "://") {
+			logger.Warnf("Skipping playlist entry %s, only local files can be played", files[n])
+			continue
+		}
+		entries = append(entries, playlistEntry{path: files[n], title: titles[n]})
+	}
+	return entries, nil
+}
+
+// writeM3U saves an extended M3U playlist of the entries. Paths under the playlist's directory
+// are relative, so the playlist can move with its songs.
+func writeM3U(filename string, entries []playlistEntry) error {
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, e := range entries {
+		seconds := -1
+		if q, err := readQOAHeader(e.path); err == nil {
+			seconds = int(calcSongLength(q).Seconds())
+		}
+		title := e.title
+		if title == "" {
+			title = strings.TrimSuffix(filepath.Base(e.path), filepath.Ext(e.path))
+		}
+		path := e.path
+		if abs, err := filepath.Abs(e.path); err == nil {
+			path = abs
+			if rel, err := filepath.Rel(dir, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d,%s\n%s\n", seconds, title, filepath.ToSlash(path))
+	}
+	return os.WriteFile(filename, []byte(b.String()), 0o644)
+}
+
+// playlist is the songs a player plays, and the order it plays them in.
+type playlist struct {
+	filenames []string
+	// titles are the titles given by playlist files, in step with filenames. They may be empty.
+	titles []string
+	order  *playOrder
+	// saveAs is the file the play order is saved to
+	saveAs string
+}
+
+// title is the title of song i, or its filename if it has none.
+func (p *playlist) title(i int) string {
+	if i < len(p.titles) && p.titles[i] != "" {
+		return p.titles[i]
+	}
+	return p.filenames[i]
+}
+
+// save writes the songs to saveAs as an M3U playlist, in the order they play in.
+func (p *playlist) save() error {
+	entries := make([]playlistEntry, len(p.order.order))
+	for i, index := range p.order.order {
+		entries[i] = playlistEntry{path: p.filenames[index]}
+		if index < len(p.titles) {
+			entries[i].title = p.titles[index]
+		}
+	}
+	return writeM3U(p.saveAs, entries)
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/braheezy/qoa"
+)
+
+// qoaFrame is one frame of a QOA file. Frames are self-contained: the LMS state needed to
+// decode them is stored in the frame itself, so they can be copied between files verbatim.
+type qoaFrame struct {
+	// channels is the number of channels in this frame.
+	channels uint32
+	// sampleRate is the sample rate of this frame.
+	sampleRate uint32
+	// samples is the number of samples per channel in this frame.
+	samples uint32
+	// data is the encoded frame, including its header.
+	data []byte
+}
+
+// qoaFrameHeaderSize is the size of the header at the start of every frame.
+const qoaFrameHeaderSize = 8
+
+// full reports whether the frame holds the maximum number of samples. Every frame but the
+// last of a file must be full.
+func (f qoaFrame) full() bool {
+	return f.samples == qoa.QOAFrameLen
+}
+
+// parseQOAFrames splits QOA file bytes into its frames, validating every frame header.
+func parseQOAFrames(data []byte) (*qoa.QOA, []qoaFrame, error) {
+	q, err := qoa.DecodeHeader(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var frames []qoaFrame
+	p := 8
+	totalSamples := uint32(0)
+	for totalSamples < q.Samples && p < len(data) {
+		frame, err := parseQOAFrame(data[p:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("frame %d: %w", len(frames), err)
+		}
+		if frame.channels != q.Channels || frame.sampleRate != q.SampleRate {
+			return nil, nil, fmt.Errorf("frame %d: format changes mid-file", len(frames))
+		}
+		if totalSamples+frame.samples > q.Samples {
+			return nil, nil, fmt.Errorf("frame %d: more samples than the file header", len(frames))
+		}
+		frames = append(frames, frame)
+		p += len(frame.data)
+		totalSamples += frame.samples
+	}
+	if len(frames) == 0 {
+		return nil, nil, errors.New("qoa: no frames found")
+	}
+
+	q.Samples = totalSamples
+	return q, frames, nil
+}
+
+// parseQOAFrame validates the frame header at the start of data and returns the frame.
+func parseQOAFrame(data []byte) (qoaFrame, error) {
+	if len(data) < qoaFrameHeaderSize {
+		return qoaFrame{}, io.ErrUnexpectedEOF
+	}
+	frameHeader := binary.BigEndian.Uint64(data)
+	f := qoaFrame{
+		channels:   uint32(frameHeader>>56) & 0xff,
+		sampleRate: uint32(frameHeader>>32) & 0xffffff,
+		samples:    uint32(frameHeader>>16) & 0xffff,
+	}
+	frameSize := int(frameHeader & 0xffff)
+
+	if f.channels == 0 || f.channels > qoa.QOAMaxChannels || f.sampleRate == 0 || f.samples == 0 || f.samples > qoa.QOAFrameLen {
+		return qoaFrame{}, errors.New("invalid frame header")
+	}
+	slices := (int(f.samples) + qoa.QOASliceLen - 1) / qoa.QOASliceLen
+	if frameSize != qoaFrameHeaderSize+qoa.QOALMSLen*4*int(f.channels)+8*slices*int(f.channels) {
+		return qoaFrame{}, errors.New("frame size does not match its sample count")
+	}
+	if frameSize > len(data) {
+		return qoaFrame{}, io.ErrUnexpectedEOF
+	}
+
+	f.data = data[:frameSize]
+	return f, nil
+}
+
+// decodeQOA decodes QOA file bytes like qoa.Decode, but validates every frame first.
+// qoa.Decode trusts the file header, so a malformed file can make it allocate gigabytes or
+// panic. The sample buffer here only grows with frames that are actually present.
+func decodeQOA(data []byte) (*qoa.QOA, []int16, error) {
+	q, frames, err := parseQOAFrames(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	decodedData := make([]int16, 0, int(q.Samples)*int(q.Channels))
+	for i, f := range frames {
+		samples, err := decodeQOAFrame(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("frame %d: %w", i, err)
+		}
+		decodedData = append(decodedData, samples...)
+	}
+	return q, decodedData, nil
+}
+
+// encodeQOA encodes samples described by q, checking first that q doesn't claim more
+// samples than there are, which would make q.Encode panic.
+func encodeQOA(q *qoa.QOA, decodedData []int16) ([]byte, error) {
+	if uint64(q.Samples)*uint64(q.Channels) > uint64(len(decodedData)) {
+		return nil, fmt.Errorf("expected %d samples for %d channels, got %d", q.Samples, q.Channels, len(decodedData)/max(int(q.Channels), 1))
+	}
+	return q.Encode(decodedData)
+}
+
+// decodeQOAFrame decodes a single frame to interleaved samples.
+func decodeQOAFrame(f qoaFrame) ([]int16, error) {
+	// A frame wrapped in a file header is a complete QOA file.
+	file := make([]byte, 8, 8+len(f.data))
+	binary.BigEndian.PutUint32(file, qoa.QOAMagic)
+	binary.BigEndian.PutUint32(file[4:], f.samples)
+	file = append(file, f.data...)
+
+	_, samples, err := qoa.Decode(file)
+	return samples, err
+}
+
+// encodeQOAFrames encodes samples and returns the resulting frames.
+func encodeQOAFrames(samples []int16, sampleRate, channels uint32) ([]qoaFrame, error) {
+	q := qoa.NewEncoder(sampleRate, channels, uint32(len(samples))/channels)
+	encoded, err := q.Encode(samples)
+	if err != nil {
+		return nil, err
+	}
+	_, frames, err := parseQOAFrames(encoded)
+	return frames, err
+}
+
+// writeQOAFrames writes a QOA file made of the given frames.
+func writeQOAFrames(w io.Writer, frames []qoaFrame) error {
+	totalSamples := uint64(0)
+	for _, f := range frames {
+		totalSamples += uint64(f.samples)
+	}
+	if totalSamples == 0 || totalSamples > 0xffffffff {
+		return fmt.Errorf("cannot store %d samples in a QOA file", totalSamples)
+	}
+
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, qoa.QOAMagic)
+	binary.BigEndian.PutUint32(header[4:], uint32(totalSamples))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for _, f := range frames {
+		if _, err := w.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/braheezy/qoa"
+)
+
+// qoaStreamSource is a sampleSource that decodes a QOA file a frame at a time, as the samples
+// are needed. Every frame but the last holds the same number of samples, so they are all the
+// same size, and any frame can be found without reading those before it.
+type qoaStreamSource struct {
+	r io.ReadSeeker
+	q qoa.QOA
+	// frameSize is the size of a full frame in bytes
+	frameSize int64
+	// frames is the number of sample frames in the file
+	frames int
+	// cache holds the last frames decoded, since playback reads the same frame many times
+	cache [2]decodedFrame
+	// oldest is the cache slot to decode into next
+	oldest int
+	buf    []byte
+}
+
+type decodedFrame struct {
+	index   int
+	samples []int16
+}
+
+// readQOAHeader reads the format of a QOA file from its header, without reading the rest of it.
+func readQOAHeader(filename string) (*qoa.QOA, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	header := make([]byte, qoa.QOAMinFilesize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, fmt.Errorf("reading QOA header: %w", err)
+	}
+	return qoa.DecodeHeader(header)
+}
+
+// qoaFrameSize returns the size of a frame of samples per channel.
+func qoaFrameSize(samples, channels int) int64 {
+	slices := (samples + qoa.QOASliceLen - 1) / qoa.QOASliceLen
+	return int64(qoaFrameHeaderSize + qoa.QOALMSLen*4*channels + 8*slices*channels)
+}
+
+// newQOAStreamSource reads the header of the QOA file in r. Nothing else is read until samples are.
+func newQOAStreamSource(r io.ReadSeeker) (*qoaStreamSource, error) {
+	header := make([]byte, qoa.QOAMinFilesize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("reading QOA header: %w", err)
+	}
+	q, err := qoa.DecodeHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if q.Channels > qoa.QOAMaxChannels {
+		return nil, fmt.Errorf("qoa: %d channels is more than the maximum of %d", q.Channels, qoa.QOAMaxChannels)
+	}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &qoaStreamSource{
+		r:         r,
+		q:         *q,
+		frameSize: qoaFrameSize(qoa.QOAFrameLen, int(q.Channels)),
+		frames:    int(q.Samples),
+	}
+	for i := range s.cache {
+		s.cache[i].index = -1
+	}
+
+	// A file cut short plays up to its last whole frame
+	fullFrames := int64(q.Samples / qoa.QOAFrameLen)
+	expected := 8 + fullFrames*s.frameSize
+	if rest := int(q.Samples % qoa.QOAFrameLen); rest > 0 {
+		expected += qoaFrameSize(rest, int(q.Channels))
+	}
+	if size < expected {
+		s.frames = int((size-8)/s.frameSize) * qoa.QOAFrameLen
+		if s.frames == 0 {
+			return nil, errors.New("qoa: no complete frames found")
+		}
+		s.q.Samples = uint32(s.frames)
+	}
+	return s, nil
+}
+
+func (s *qoaStreamSource) SampleRate() int { return int(s.q.SampleRate) }
+func (s *qoaStreamSource) Channels() int   { return int(s.q.Channels) }
+func (s *qoaStreamSource) Frames() int     { return s.frames }
+
+func (s *qoaStreamSource) ReadFrames(dst []int16, start int) (int, error) {
+	if start < 0 || start > s.frames {
+		return 0, errors.New("read out of range")
+	}
+	channels := s.Channels()
+	n := 0
+	for (n+1)*channels <= len(dst) && start+n < s.frames {
+		pos := start + n
+		samples, err := s.frame(pos / qoa.QOAFrameLen)
+		if err != nil {
+			return n, err
+		}
+		n += copy(dst[n*channels:], samples[(pos%qoa.QOAFrameLen)*channels:]) / channels
+	}
+	return n, nil
+}
+
+// frame returns the samples of frame i, decoding it if it isn't cached.
+func (s *qoaStreamSource) frame(i int) ([]int16, error) {
+	for _, f := range s.cache {
+		if f.index == i {
+			return f.samples, nil
+		}
+	}
+
+	samples := min(s.frames-i*qoa.QOAFrameLen, qoa.QOAFrameLen)
+	size := qoaFrameSize(samples, s.Channels())
+	if int64(cap(s.buf)) < size {
+		s.buf = make([]byte, size)
+	}
+	buf := s.buf[:size]
+	if _, err := s.r.Seek(8+int64(i)*s.frameSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(s.r, buf); err != nil {
+		return nil, fmt.Errorf("frame %d: %w", i, err)
+	}
+	f, err := parseQOAFrame(buf)
+	if err != nil {
+		return nil, fmt.Errorf("frame %d: %w", i, err)
+	}
+	if f.channels != s.q.Channels || f.sampleRate != s.q.SampleRate || int(f.samples) != samples {
+		return nil, fmt.Errorf("frame %d: format changes mid-file", i)
+	}
+	decoded, err := decodeQOAFrame(f)
+	if err != nil {
+		return nil, fmt.Errorf("frame %d: %w", i, err)
+	}
+
+	s.cache[s.oldest] = decodedFrame{index: i, samples: decoded}
+	s.oldest = (s.oldest + 1) % len(s.cache)
+	return decoded, nil
+}
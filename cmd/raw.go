@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/braheezy/qoa"
+)
+
+// rawPCMFormat describes headerless PCM audio. Nothing in the data says what it is,
+// so it has to be given.
+type rawPCMFormat struct {
+	sampleRate int
+	channels   int
+	// encoding is the sample encoding, one of rawEncodings
+	encoding string
+}
+
+// rawPCM is the format of .pcm and .raw files, and of audio piped through stdin and stdout.
+var rawPCM = rawPCMFormat{sampleRate: 44100, channels: 2, encoding: "s16le"}
+
+// rawEncodings maps the supported sample encodings to their size in bytes.
+var rawEncodings = map[string]int{
+	"s16le": 2,
+	"s16be": 2,
+	"u8":    1,
+	"f32le": 4,
+}
+
+// stdioFilename is the filename that reads raw PCM from stdin, or writes it to stdout.
+const stdioFilename = "-"
+
+func (f rawPCMFormat) validate() error {
+	if _, ok := rawEncodings[f.encoding]; !ok {
+		return fmt.Errorf("unknown raw sample format %q, expected s16le, s16be, u8 or f32le", f.encoding)
+	}
+	if f.sampleRate < 1 || f.sampleRate > 0xffffff {
+		return fmt.Errorf("sample rate %d out of range", f.sampleRate)
+	}
+	if f.channels < 1 || f.channels > qoa.QOAMaxChannels {
+		return fmt.Errorf("channel count %d out of range 1-%d", f.channels, qoa.QOAMaxChannels)
+	}
+	return nil
+}
+
+// decodeRaw converts interleaved raw PCM to 16-bit samples.
+func decodeRaw(inputData []byte, f rawPCMFormat) ([]int16, *qoa.QOA, error) {
+	if err := f.validate(); err != nil {
+		return nil, nil, err
+	}
+	sampleSize := rawEncodings[f.encoding]
+	frameSize := sampleSize * f.channels
+	if extra := len(inputData) % frameSize; extra != 0 {
+		logger.Warnf("Ignoring %d bytes of incomplete sample frame at the end of the raw data", extra)
+		inputData = inputData[:len(inputData)-extra]
+	}
+
+	decodedData := make([]int16, len(inputData)/sampleSize)
+	for i := range decodedData {
+		b := inputData[i*sampleSize:]
+		switch f.encoding {
+		case "s16le":
+			decodedData[i] = int16(binary.LittleEndian.Uint16(b))
+		case "s16be":
+			decodedData[i] = int16(binary.BigEndian.Uint16(b))
+		case "u8":
+			decodedData[i] = int16(int(b[0])-128) << 8
+		case "f32le":
+			v := float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+			if math.IsNaN(v) {
+				v = 0
+			}
+			decodedData[i] = clampInt16(int(math.Round(math.Max(-2, math.Min(2, v)) * 32768)))
+		}
+	}
+
+	q := qoa.NewEncoder(uint32(f.sampleRate), uint32(f.channels), uint32(len(decodedData)/f.channels))
+	return decodedData, q, nil
+}
+
+// encodeRaw writes 16-bit samples as interleaved raw PCM in the given sample encoding.
+func encodeRaw(w io.Writer, decodedData []int16, encoding string) error {
+	sampleSize, ok := rawEncodings[encoding]
+	if !ok {
+		return fmt.Errorf("unknown raw sample format %q, expected s16le, s16be, u8 or f32le", encoding)
+	}
+
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, sampleSize)
+	for _, v := range decodedData {
+		switch encoding {
+		case "s16le":
+			binary.LittleEndian.PutUint16(buf, uint16(v))
+		case "s16be":
+			binary.BigEndian.PutUint16(buf, uint16(v))
+		case "u8":
+			buf[0] = byte((v >> 8) + 128)
+		case "f32le":
+			binary.LittleEndian.PutUint32(buf, math.Float32bits(float32(v)/32768))
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
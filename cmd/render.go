@@ -0,0 +1,334 @@
+package cmd
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/braheezy/qoa"
+	"github.com/spf13/cobra"
+)
+
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Render audio as an image",
+	Long:  "Render a spectrogram or waveform of any supported audio file as a PNG image.",
+}
+
+var renderSpectrogramCmd = &cobra.Command{
+	Use:   "spectrogram <file>",
+	Short: "Render a spectrogram",
+	Long: `Render a spectrogram of the audio, with time on the x-axis and frequency on the y-axis.
+
+With --diff, the file is encoded to QOA in memory and the spectrogram shows the difference
+between the source and the encoded audio, making the coding noise visible.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		inputFile := args[0]
+		opts := spectrogramOptions{}
+		opts.width, opts.height = renderSize(cmd)
+		opts.fftSize, _ = cmd.Flags().GetInt("fft-size")
+		opts.window, _ = cmd.Flags().GetString("window")
+		opts.scale, _ = cmd.Flags().GetString("scale")
+		opts.dbRange, _ = cmd.Flags().GetFloat64("db-range")
+		colormapName, _ := cmd.Flags().GetString("colormap")
+		diff, _ := cmd.Flags().GetBool("diff")
+
+		var ok bool
+		if opts.colormap, ok = colormaps[colormapName]; !ok {
+			logger.Fatalf("Unknown colormap %q, expected one of: %s", colormapName, strings.Join(colormapNames(), ", "))
+		}
+
+		decodedData, q, err := decodeAudio(inputFile)
+		if err != nil {
+			logger.Fatalf("Error decoding %s: %v", inputFile, err)
+		}
+		if diff {
+			if hasQOAExtension(inputFile) {
+				logger.Fatal("--diff needs the source audio, not a QOA file")
+			}
+			decodedData, err = qoaCodingNoise(decodedData, q)
+			if err != nil {
+				logger.Fatalf("Error encoding %s: %v", inputFile, err)
+			}
+		}
+
+		img, err := renderSpectrogram(mixToMono(decodedData, int(q.Channels)), q.SampleRate, opts)
+		if err != nil {
+			logger.Fatalf("Error rendering spectrogram: %v", err)
+		}
+		writePNG(renderOutputFile(cmd, inputFile, "spectrogram"), img)
+	},
+}
+
+var renderWaveformCmd = &cobra.Command{
+	Use:   "waveform <file>",
+	Short: "Render a waveform",
+	Long:  "Render the waveform of the audio, with one lane per channel.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		inputFile := args[0]
+		width, height := renderSize(cmd)
+
+		decodedData, q, err := decodeAudio(inputFile)
+		if err != nil {
+			logger.Fatalf("Error decoding %s: %v", inputFile, err)
+		}
+
+		img := renderWaveform(decodedData, int(q.Channels), width, height)
+		writePNG(renderOutputFile(cmd, inputFile, "waveform"), img)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(renderCmd)
+	renderCmd.AddCommand(renderSpectrogramCmd, renderWaveformCmd)
+
+	renderCmd.PersistentFlags().StringP("output", "o", "", "Output PNG file (default <file>-<kind>.png)")
+	renderCmd.PersistentFlags().Int("width", 1200, "Image width in pixels")
+	renderCmd.PersistentFlags().Int("height", 400, "Image height in pixels")
+
+	renderSpectrogramCmd.Flags().Int("fft-size", 2048, "FFT size, a power of 2")
+	renderSpectrogramCmd.Flags().String("window", "hann", "FFT window: rect, hann, hamming or blackman")
+	renderSpectrogramCmd.Flags().String("scale", "log", "Frequency scale: linear, log or mel")
+	renderSpectrogramCmd.Flags().String("colormap", "magma", fmt.Sprintf("Color map: %s", strings.Join(colormapNames(), ", ")))
+	renderSpectrogramCmd.Flags().Float64("db-range", 120, "Dynamic range shown, in dB below full scale")
+	renderSpectrogramCmd.Flags().Bool("diff", false, "Render the difference between the source and its QOA encode")
+}
+
+func renderSize(cmd *cobra.Command) (int, int) {
+	width, _ := cmd.Flags().GetInt("width")
+	height, _ := cmd.Flags().GetInt("height")
+	if width <= 0 || height <= 0 {
+		logger.Fatalf("Invalid image size %dx%d", width, height)
+	}
+	return width, height
+}
+
+func renderOutputFile(cmd *cobra.Command, inputFile, kind string) string {
+	outputFile, _ := cmd.Flags().GetString("output")
+	if outputFile == "" {
+		outputFile = strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile)) + "-" + kind + ".png"
+	}
+	return outputFile
+}
+
+func writePNG(outputFile string, img image.Image) {
+	f, err := os.Create(outputFile)
+	if err != nil {
+		logger.Fatalf("Error creating %s: %v", outputFile, err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		logger.Fatalf("Error writing %s: %v", outputFile, err)
+	}
+	logger.Infof("Rendered %s", outputFile)
+}
+
+// qoaCodingNoise encodes decodedData to QOA and returns what the round trip changed.
+func qoaCodingNoise(decodedData []int16, q *qoa.QOA) ([]int16, error) {
+	encoded, err := encodeQOA(q, decodedData)
+	if err != nil {
+		return nil, err
+	}
+	_, roundTrip, err := qoa.Decode(encoded)
+	if err != nil {
+		return nil, err
+	}
+	noise := make([]int16, min(len(decodedData), len(roundTrip)))
+	for i := range noise {
+		noise[i] = clampInt16(int(decodedData[i]) - int(roundTrip[i]))
+	}
+	return noise, nil
+}
+
+// mixToMono averages the channels of interleaved samples, normalized to [-1, 1].
+func mixToMono(decodedData []int16, channels int) []float64 {
+	mono := make([]float64, len(decodedData)/channels)
+	for i := range mono {
+		sum := 0
+		for c := 0; c < channels; c++ {
+			sum += int(decodedData[i*channels+c])
+		}
+		mono[i] = float64(sum) / float64(channels) / 32768
+	}
+	return mono
+}
+
+func clampInt16(v int) int16 {
+	return int16(max(math.MinInt16, min(math.MaxInt16, v)))
+}
+
+type spectrogramOptions struct {
+	width, height int
+	fftSize       int
+	window        string
+	// scale is the frequency axis scale: linear, log or mel
+	scale    string
+	dbRange  float64
+	colormap colormap
+}
+
+// renderSpectrogram draws one FFT per image column, spread evenly over the audio.
+func renderSpectrogram(mono []float64, sampleRate uint32, opts spectrogramOptions) (*image.RGBA, error) {
+	analyzer, err := newSpectrumAnalyzer(opts.fftSize, opts.window)
+	if err != nil {
+		return nil, err
+	}
+	binForRow, err := frequencyBins(opts.scale, opts.height, opts.fftSize, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, opts.width, opts.height))
+	var magnitudes []float64
+	for x := 0; x < opts.width; x++ {
+		center := int(float64(x) / float64(opts.width) * float64(len(mono)))
+		start := center - opts.fftSize/2
+		block := make([]float64, opts.fftSize)
+		for i := range block {
+			if j := start + i; j >= 0 && j < len(mono) {
+				block[i] = mono[j]
+			}
+		}
+		magnitudes = analyzer.magnitudesDB(block, magnitudes)
+
+		for y := 0; y < opts.height; y++ {
+			// Fractional bins are linearly interpolated
+			bin := binForRow[y]
+			lower := int(bin)
+			upper := min(lower+1, len(magnitudes)-1)
+			frac := bin - float64(lower)
+			db := magnitudes[lower]*(1-frac) + magnitudes[upper]*frac
+
+			level := 1 + db/opts.dbRange
+			img.Set(x, y, opts.colormap.at(level))
+		}
+	}
+	return img, nil
+}
+
+// frequencyBins maps every image row, top to bottom, to a fractional FFT bin on the given scale.
+func frequencyBins(scale string, height, fftSize int, sampleRate uint32) ([]float64, error) {
+	nyquist := float64(sampleRate) / 2
+	binWidth := float64(sampleRate) / float64(fftSize)
+	lowest := math.Max(20, binWidth)
+
+	var frequencyAt func(t float64) float64
+	switch scale {
+	case "linear":
+		frequencyAt = func(t float64) float64 { return t * nyquist }
+	case "log":
+		frequencyAt = func(t float64) float64 { return lowest * math.Pow(nyquist/lowest, t) }
+	case "mel":
+		toMel := func(f float64) float64 { return 2595 * math.Log10(1+f/700) }
+		maxMel := toMel(nyquist)
+		frequencyAt = func(t float64) float64 { return 700 * (math.Pow(10, t*maxMel/2595) - 1) }
+	default:
+		return nil, fmt.Errorf("unknown frequency scale %q", scale)
+	}
+
+	bins := make([]float64, height)
+	for y := range bins {
+		t := 1 - float64(y)/float64(max(height-1, 1))
+		bins[y] = math.Min(frequencyAt(t)/binWidth, float64(fftSize/2-1))
+	}
+	return bins, nil
+}
+
+// renderWaveform draws the min/max envelope and RMS of every channel in its own lane.
+func renderWaveform(decodedData []int16, channels, width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	background := parseHexColor(black)
+	peakColor := parseHexColor(qoaRed)
+	rmsColor := parseHexColor(qoaPink)
+	axisColor := parseHexColor(greenLight)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, background)
+		}
+	}
+
+	totalSamples := len(decodedData) / channels
+	laneHeight := height / channels
+	for c := 0; c < channels; c++ {
+		top := c * laneHeight
+		mid := top + laneHeight/2
+		toY := func(v float64) int {
+			return mid - int(v*float64(laneHeight/2-1))
+		}
+
+		for x := 0; x < width; x++ {
+			start := x * totalSamples / width
+			end := max((x+1)*totalSamples/width, start+1)
+			lo, hi, sumSquares := 1.0, -1.0, 0.0
+			for i := start; i < end && i < totalSamples; i++ {
+				v := float64(decodedData[i*channels+c]) / 32768
+				lo, hi = math.Min(lo, v), math.Max(hi, v)
+				sumSquares += v * v
+			}
+			if lo > hi {
+				continue
+			}
+			rms := math.Sqrt(sumSquares / float64(end-start))
+
+			for y := toY(hi); y <= toY(lo); y++ {
+				img.Set(x, y, peakColor)
+			}
+			for y := toY(math.Min(rms, hi)); y <= toY(math.Max(-rms, lo)); y++ {
+				img.Set(x, y, rmsColor)
+			}
+		}
+		for x := 0; x < width; x++ {
+			if img.RGBAAt(x, mid) == background {
+				img.Set(x, mid, axisColor)
+			}
+		}
+	}
+	return img
+}
+
+func parseHexColor(hex string) color.RGBA {
+	var r, g, b uint8
+	fmt.Sscanf(hex, "#%02x%02x%02x", &r, &g, &b)
+	return color.RGBA{R: r, G: g, B: b, A: 0xff}
+}
+
+// colormap maps a level in [0, 1] to a color by interpolating between evenly spaced stops.
+type colormap []color.RGBA
+
+func (c colormap) at(level float64) color.RGBA {
+	level = math.Max(0, math.Min(1, level))
+	pos := level * float64(len(c)-1)
+	i := int(pos)
+	if i >= len(c)-1 {
+		return c[len(c)-1]
+	}
+	frac := pos - float64(i)
+	lerp := func(a, b uint8) uint8 { return uint8(float64(a) + (float64(b)-float64(a))*frac) }
+	return color.RGBA{R: lerp(c[i].R, c[i+1].R), G: lerp(c[i].G, c[i+1].G), B: lerp(c[i].B, c[i+1].B), A: 0xff}
+}
+
+var colormaps = map[string]colormap{
+	"gray": {parseHexColor("#000000"), parseHexColor("#ffffff")},
+	"magma": {
+		parseHexColor("#000004"), parseHexColor("#1c1044"), parseHexColor("#4f127b"), parseHexColor("#812581"),
+		parseHexColor("#b5367a"), parseHexColor("#e55064"), parseHexColor("#fb8761"), parseHexColor("#fec287"),
+		parseHexColor("#fcfdbf"),
+	},
+	"viridis": {
+		parseHexColor("#440154"), parseHexColor("#472d7b"), parseHexColor("#3b528b"), parseHexColor("#2c728e"),
+		parseHexColor("#21918c"), parseHexColor("#28ae80"), parseHexColor("#5ec962"), parseHexColor("#addc30"),
+		parseHexColor("#fde725"),
+	},
+	"qoa": {parseHexColor(black), parseHexColor(qoaRed), parseHexColor(qoaPink), parseHexColor("#ffffff")},
+}
+
+func colormapNames() []string {
+	return []string{"magma", "viridis", "gray", "qoa"}
+}
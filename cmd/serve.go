@@ -0,0 +1,397 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/braheezy/qoa"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [<directory>]",
+	Short: "Serve a directory of QOA files over HTTP",
+	Long: `Start an HTTP server to browse and stream the QOA files found in a directory.
+
+Routes:
+  /                       HTML page listing all files, with players
+  /files/<path>           the raw QOA file, with Range support
+  /files/<path>?format=   the file transcoded to wav or mp3, for browsers without QOA support
+  /api/files              JSON list of all files and their header metadata
+  /api/files/<path>       JSON header metadata of one file
+
+If no directory is provided, the current directory is served.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		root := "."
+		if len(args) > 0 {
+			root = args[0]
+		}
+		addr, _ := cmd.Flags().GetString("addr")
+
+		info, err := os.Stat(root)
+		if err != nil {
+			logger.Fatalf("Error accessing %s: %v", root, err)
+		}
+		if !info.IsDir() {
+			logger.Fatalf("%s is not a directory", root)
+		}
+
+		logger.Infof("Serving QOA files in %s on http://%s", root, addr)
+		if err := http.ListenAndServe(addr, newLibraryHandler(root)); err != nil {
+			logger.Fatalf("Error serving: %v", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringP("addr", "a", "localhost:8080", "Address to listen on, use :8080 to listen on all interfaces")
+}
+
+// libraryServer serves the QOA files below root.
+type libraryServer struct {
+	root       string
+	transcodes *transcodeCache
+}
+
+// qoaFileInfo is the header metadata of a QOA file, as served by the JSON endpoints.
+type qoaFileInfo struct {
+	Path       string  `json:"path"`
+	Size       int64   `json:"size"`
+	SampleRate uint32  `json:"sample_rate"`
+	Channels   uint32  `json:"channels"`
+	Samples    uint32  `json:"samples"`
+	Frames     uint32  `json:"frames"`
+	Duration   float64 `json:"duration"`
+	Bitrate    float64 `json:"bitrate_kbps"`
+}
+
+func newLibraryHandler(root string) http.Handler {
+	s := &libraryServer{root: root, transcodes: newTranscodeCache()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.handleIndex)
+	mux.HandleFunc("GET /api/files", s.handleList)
+	mux.HandleFunc("GET /api/files/{path...}", s.handleMetadata)
+	mux.HandleFunc("GET /files/{path...}", s.handleFile)
+	return logRequests(mux)
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		logger.Debug(r.Method, "url", r.URL.String(), "remote", r.RemoteAddr, "took", time.Since(start))
+	})
+}
+
+// resolve maps a slash separated path from a URL to a QOA file below root.
+func (s *libraryServer) resolve(rel string) (string, error) {
+	// Cleaning the path as absolute drops any ".." that would escape root
+	p := filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+rel)))
+	if valid, err := qoa.IsValidQOAFile(p); !valid {
+		return "", err
+	}
+	return p, nil
+}
+
+// library lists every QOA file below root.
+func (s *libraryServer) library() ([]qoaFileInfo, error) {
+	files, err := findAllQOAFiles(s.root)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]qoaFileInfo, 0, len(files))
+	for _, f := range files {
+		info, err := s.fileInfo(f)
+		if err != nil {
+			logger.Warnf("Skipping %s: %v", f, err)
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// fileInfo reads the header metadata of a QOA file.
+func (s *libraryServer) fileInfo(filename string) (qoaFileInfo, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return qoaFileInfo{}, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return qoaFileInfo{}, err
+	}
+	header := make([]byte, qoa.QOAMinFilesize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return qoaFileInfo{}, err
+	}
+	q, err := qoa.DecodeHeader(header)
+	if err != nil {
+		return qoaFileInfo{}, err
+	}
+
+	rel, err := filepath.Rel(s.root, filename)
+	if err != nil {
+		return qoaFileInfo{}, err
+	}
+	duration := calcSongLength(q).Seconds()
+	return qoaFileInfo{
+		Path:       filepath.ToSlash(rel),
+		Size:       stat.Size(),
+		SampleRate: q.SampleRate,
+		Channels:   q.Channels,
+		Samples:    q.Samples,
+		Frames:     (q.Samples + qoa.QOAFrameLen - 1) / qoa.QOAFrameLen,
+		Duration:   duration,
+		Bitrate:    float64(stat.Size()*8) / duration / 1000,
+	}, nil
+}
+
+func (s *libraryServer) handleList(w http.ResponseWriter, r *http.Request) {
+	infos, err := s.library()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, infos)
+}
+
+func (s *libraryServer) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	filename, err := s.resolve(r.PathValue("path"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := s.fileInfo(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, info)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Errorf("Error writing JSON response: %v", err)
+	}
+}
+
+func (s *libraryServer) handleFile(w http.ResponseWriter, r *http.Request) {
+	filename, err := s.resolve(r.PathValue("path"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	format := r.URL.Query().Get("format")
+
+	if format == "" || format == "qoa" {
+		f, err := os.Open(filename)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, filepath.Base(filename), stat.ModTime(), f)
+		return
+	}
+	if format != "wav" && format != "mp3" {
+		http.Error(w, "unsupported format, expected qoa, wav or mp3", http.StatusBadRequest)
+		return
+	}
+
+	stat, err := os.Stat(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, contentType, err := s.transcodes.get(filename, format, stat)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)) + "." + format
+	http.ServeContent(w, r, name, stat.ModTime(), bytes.NewReader(data))
+}
+
+// transcodeCacheSize is how many transcoded files are kept. A browser seeking in a file sends
+// a Range request for every seek, which shouldn't transcode the whole file again.
+const transcodeCacheSize = 8
+
+// transcodeKey identifies a transcode of a file as it was when it was last modified.
+type transcodeKey struct {
+	filename, format string
+	modTime          time.Time
+	size             int64
+}
+
+// transcode is a transcoded file, ready once done is closed.
+type transcode struct {
+	done        chan struct{}
+	data        []byte
+	contentType string
+	err         error
+}
+
+// transcodeCache keeps the most recently used transcodes. Requests for a file being transcoded
+// wait for it rather than transcoding it again.
+type transcodeCache struct {
+	mu      sync.Mutex
+	entries map[transcodeKey]*transcode
+	// used holds the keys from least to most recently used
+	used []transcodeKey
+}
+
+func newTranscodeCache() *transcodeCache {
+	return &transcodeCache{entries: map[transcodeKey]*transcode{}}
+}
+
+// get returns filename transcoded to format, as it is when stat was taken.
+func (c *transcodeCache) get(filename, format string, stat os.FileInfo) ([]byte, string, error) {
+	key := transcodeKey{filename: filename, format: format, modTime: stat.ModTime(), size: stat.Size()}
+	c.mu.Lock()
+	t, ok := c.entries[key]
+	if ok {
+		c.used = slices.DeleteFunc(c.used, func(k transcodeKey) bool { return k == key })
+	} else {
+		t = &transcode{done: make(chan struct{})}
+		c.entries[key] = t
+		if len(c.used) >= transcodeCacheSize {
+			delete(c.entries, c.used[0])
+			c.used = c.used[1:]
+		}
+	}
+	c.used = append(c.used, key)
+	c.mu.Unlock()
+
+	if !ok {
+		t.data, t.contentType, t.err = transcodeQOA(filename, format)
+		close(t.done)
+		if t.err != nil {
+			// Failures aren't kept, the next request tries again
+			c.forget(key, t)
+		}
+	}
+	<-t.done
+	return t.data, t.contentType, t.err
+}
+
+// forget drops the transcode of key, if it's still t.
+func (c *transcodeCache) forget(key transcodeKey, t *transcode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[key] == t {
+		delete(c.entries, key)
+		c.used = slices.DeleteFunc(c.used, func(k transcodeKey) bool { return k == key })
+	}
+}
+
+// transcodeQOA decodes a QOA file and encodes it to the given format.
+func transcodeQOA(filename, format string) ([]byte, string, error) {
+	qoaBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	q, decodedData, err := decodeQOA(qoaBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch format {
+	case "wav":
+		var out memWriteSeeker
+		if err := encodeWAV(&out, q, decodedData); err != nil {
+			return nil, "", err
+		}
+		return out.Bytes(), "audio/wav", nil
+	case "mp3":
+		var out bytes.Buffer
+		if err := writeMp3(&out, q, decodedData); err != nil {
+			return nil, "", err
+		}
+		return out.Bytes(), "audio/mpeg", nil
+	default:
+		return nil, "", errors.New("unsupported format, expected qoa, wav or mp3")
+	}
+}
+
+func (s *libraryServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	infos, err := s.library()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, infos); err != nil {
+		logger.Errorf("Error rendering index: %v", err)
+	}
+}
+
+// escapeURLPath escapes every segment of a slash separated path.
+func escapeURLPath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"fileURL": func(p string) string { return "/files/" + escapeURLPath(p) },
+	"metaURL": func(p string) string { return "/api/files/" + escapeURLPath(p) },
+	"duration": func(seconds float64) string {
+		return formatDuration(time.Duration(seconds * float64(time.Second)))
+	},
+	"hz": func(rate uint32) string { return fmt.Sprintf("%d Hz", rate) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>goqoa</title>
+<style>
+body { font-family: sans-serif; background: #191724; color: #e0def4; margin: 2em; }
+h1 { color: #dd81c7; }
+td { padding: 0.3em 0.8em; }
+a { color: #9ccfd8; }
+</style>
+</head>
+<body>
+<h1>goqoa</h1>
+<table>
+<tr><th>File</th><th>Duration</th><th>Format</th><th>Listen</th><th>Download</th></tr>
+{{range .}}<tr>
+<td>{{.Path}}</td>
+<td>{{duration .Duration}}</td>
+<td>{{hz .SampleRate}}, {{.Channels}} ch</td>
+<td><audio controls preload="none" src="{{fileURL .Path}}?format=wav"></audio></td>
+<td><a href="{{fileURL .Path}}">qoa</a> <a href="{{fileURL .Path}}?format=wav">wav</a> <a href="{{fileURL .Path}}?format=mp3">mp3</a> <a href="{{metaURL .Path}}">info</a></td>
+</tr>
+{{else}}<tr><td colspan="5">No QOA files found :(</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,286 @@
+package cmd
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/braheezy/qoa"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var specCheckCmd = &cobra.Command{
+	Use:   "spec-check <dir-or-zip>",
+	Short: "Check conformance against the QOA reference sample pack",
+	Long: `Check conformance against the QOA reference sample pack (https://qoaformat.org/samples/).
+
+Every WAV in the pack is encoded to QOA and compared against the reference .qoa file.
+Every reference .qoa file is decoded to WAV and compared against the reference .qoa.wav file.
+The pack can be given as the downloaded zip or as an already extracted directory.
+
+Every sample is checked, then the number that match is printed. The command fails if any
+don't. Some large samples are known to differ from the reference deep into the file while
+being the same size and sounding the same, so --bytes can limit the comparison to the
+start of each file.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		failuresFile, _ := cmd.Flags().GetString("failures")
+		count, _ := cmd.Flags().GetInt("count")
+		limit, _ := cmd.Flags().GetInt("bytes")
+		if limit < 0 {
+			logger.Fatal("--bytes must not be negative")
+		}
+
+		pack, err := openSamplePack(args[0])
+		if err != nil {
+			logger.Fatalf("Error opening sample pack: %v", err)
+		}
+
+		samples := pack.samples()
+		if len(samples) == 0 {
+			logger.Fatalf("No reference samples found in %s", args[0])
+		}
+		if count > 0 && count < len(samples) {
+			rand.Shuffle(len(samples), func(i, j int) { samples[i], samples[j] = samples[j], samples[i] })
+			samples = samples[:count]
+			sort.Slice(samples, func(i, j int) bool { return samples[i].name < samples[j].name })
+		}
+
+		okStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+		failStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+
+		var failures []string
+		for _, s := range samples {
+			fmt.Printf("Checking %s...", s.name)
+			mismatches, err := checkSample(pack, s, limit)
+			if err != nil {
+				mismatches = append(mismatches, err.Error())
+			}
+			if len(mismatches) == 0 {
+				fmt.Println(okStyle.Render("OK"))
+				continue
+			}
+
+			fmt.Println(failStyle.Render("FAIL"))
+			for _, m := range mismatches {
+				fmt.Printf("\t%s\n", failStyle.Render(m))
+			}
+			failures = append(failures, s.name)
+		}
+
+		fmt.Printf("%d/%d samples match the reference\n", len(samples)-len(failures), len(samples))
+		if all {
+			if err := os.WriteFile(failuresFile, []byte(strings.Join(failures, "\n")+"\n"), 0o644); err != nil {
+				logger.Fatalf("Error writing failures: %v", err)
+			}
+			fmt.Printf("Failures recorded in %s\n", failuresFile)
+		}
+		if len(failures) > 0 {
+			logger.Fatalf("%d samples do not match the reference", len(failures))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(specCheckCmd)
+	specCheckCmd.Flags().BoolP("all", "a", false, "Record failing samples to the --failures file")
+	specCheckCmd.Flags().String("failures", "failures", "File to record failing samples to in --all mode")
+	specCheckCmd.Flags().IntP("count", "n", 0, "Only check this many randomly chosen samples (0 checks all)")
+	specCheckCmd.Flags().Int("bytes", 0, "Only compare the first N bytes of each file (0 compares whole files)")
+}
+
+// specSample is one song of the sample pack: the source WAV plus the reference files
+// created from it by the reference encoder.
+type specSample struct {
+	name string
+	// wav is the source audio
+	wav string
+	// qoa is the reference encode of wav
+	qoa string
+	// qoaWav is the reference decode of qoa
+	qoaWav string
+}
+
+// samplePack gives uniform access to the sample pack, zipped or extracted.
+type samplePack struct {
+	files map[string]func() ([]byte, error)
+}
+
+func openSamplePack(location string) (*samplePack, error) {
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, err
+	}
+
+	pack := &samplePack{files: make(map[string]func() ([]byte, error))}
+	if info.IsDir() {
+		err := filepath.Walk(location, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				pack.files[filepath.ToSlash(p)] = func() ([]byte, error) { return os.ReadFile(p) }
+			}
+			return nil
+		})
+		return pack, err
+	}
+
+	// zip.Reader keeps the file open for the lifetime of the process, that's fine for a one-shot command.
+	zr, err := zip.OpenReader(location)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		f := f
+		pack.files[f.Name] = func() ([]byte, error) {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return io.ReadAll(rc)
+		}
+	}
+	return pack, nil
+}
+
+// samples pairs every source WAV in the pack with its reference files. The reference files
+// may live in other directories than the source, so they are matched by name.
+func (p *samplePack) samples() []specSample {
+	byBase := make(map[string]string, len(p.files))
+	for name := range p.files {
+		byBase[path.Base(name)] = name
+	}
+
+	var samples []specSample
+	for name := range p.files {
+		base := path.Base(name)
+		if !strings.HasSuffix(base, ".wav") || strings.HasSuffix(base, ".qoa.wav") {
+			continue
+		}
+		stem := strings.TrimSuffix(base, ".wav")
+		s := specSample{name: stem, wav: name, qoa: byBase[stem+".qoa"], qoaWav: byBase[stem+".qoa.wav"]}
+		if s.qoa == "" {
+			continue
+		}
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].name < samples[j].name })
+	return samples
+}
+
+func (p *samplePack) read(name string) ([]byte, error) {
+	open, ok := p.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in sample pack", name)
+	}
+	return open()
+}
+
+// checkSample encodes and decodes one sample and describes every difference from the reference
+// in the first limit bytes of each file, or the whole files if limit is 0.
+func checkSample(pack *samplePack, s specSample, limit int) ([]string, error) {
+	var mismatches []string
+
+	// Encoding: source WAV -> QOA
+	wavData, err := pack.read(s.wav)
+	if err != nil {
+		return nil, err
+	}
+	decodedData, q, err := decodeWAV(wavData, s.wav)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", s.wav, err)
+	}
+	ourQOA, err := encodeQOA(q, decodedData)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s: %w", s.wav, err)
+	}
+	refQOA, err := pack.read(s.qoa)
+	if err != nil {
+		return nil, err
+	}
+	if offset := firstDifference(prefix(refQOA, limit), prefix(ourQOA, limit)); offset >= 0 {
+		mismatches = append(mismatches, fmt.Sprintf("encode differs from %s at byte %d (%s)",
+			s.qoa, offset, describeQOAOffset(offset, q.Channels)))
+	}
+
+	// Decoding: reference QOA -> WAV
+	if s.qoaWav == "" {
+		return mismatches, nil
+	}
+	refQ, refDecoded, err := decodeQOA(refQOA)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", s.qoa, err)
+	}
+	var ourWav memWriteSeeker
+	if err := encodeWAV(&ourWav, refQ, refDecoded); err != nil {
+		return nil, fmt.Errorf("writing WAV for %s: %w", s.qoa, err)
+	}
+	refWav, err := pack.read(s.qoaWav)
+	if err != nil {
+		return nil, err
+	}
+	if offset := firstDifference(prefix(refWav, limit), prefix(ourWav.Bytes(), limit)); offset >= 0 {
+		headerSize := len(refWav) - len(refDecoded)*2
+		mismatches = append(mismatches, fmt.Sprintf("decode differs from %s at byte %d (%s)",
+			s.qoaWav, offset, describeWAVOffset(offset, headerSize, refQ.Channels)))
+	}
+
+	return mismatches, nil
+}
+
+// firstDifference returns the offset of the first differing byte, or -1 if a and b are identical.
+func firstDifference(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) != len(b) {
+		return n
+	}
+	return -1
+}
+
+// prefix returns the first limit bytes of data, or all of it if limit is 0.
+func prefix(data []byte, limit int) []byte {
+	if limit > 0 && limit < len(data) {
+		return data[:limit]
+	}
+	return data
+}
+
+// describeQOAOffset names the QOA frame that contains the byte offset.
+func describeQOAOffset(offset int, channels uint32) string {
+	if offset < 8 {
+		return "file header"
+	}
+	// All frames but the last are full, so the frame index follows from the full frame size.
+	frameSize := 8 + qoa.QOALMSLen*4*int(channels) + 8*qoa.QOASlicesPerFrame*int(channels)
+	return fmt.Sprintf("frame %d", (offset-8)/frameSize)
+}
+
+// describeWAVOffset names the QOA frame that the PCM sample at the byte offset was decoded from.
+func describeWAVOffset(offset, headerSize int, channels uint32) string {
+	if offset < headerSize || headerSize < 0 {
+		return "WAV header"
+	}
+	sample := (offset - headerSize) / (2 * int(channels))
+	return fmt.Sprintf("frame %d", sample/qoa.QOAFrameLen)
+}
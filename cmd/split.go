@@ -0,0 +1,415 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/braheezy/qoa"
+	"github.com/spf13/cobra"
+)
+
+var splitCmd = &cobra.Command{
+	Use:   "split <file.qoa>",
+	Short: "Split a QOA file into pieces",
+	Long: `Split a QOA file into pieces by duration, frame count, detected silence or chapter timestamps.
+
+Cuts are moved to the nearest frame boundary so every piece is made of frames copied
+verbatim from the input. With --exact, cuts land on the exact sample. Only the last
+frame of a QOA file may be short, so a piece is re-encoded from a cut inside a frame
+to its end.
+
+Output names are made from --output, where these placeholders are replaced:
+  {name}   input file name without extension
+  {index}  piece number, starting at 001
+  {title}  chapter title (falls back to {index})
+  {start}  piece start time, e.g. 1m30s
+
+Chapter files are either CUE sheets or text files with one "[hh:]mm:ss[.ms] title" line per chapter.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		inputFile := args[0]
+		template, _ := cmd.Flags().GetString("output")
+		exact, _ := cmd.Flags().GetBool("exact")
+
+		data, err := os.ReadFile(inputFile)
+		if err != nil {
+			logger.Fatalf("Error reading %s: %v", inputFile, err)
+		}
+		q, frames, err := parseQOAFrames(data)
+		if err != nil {
+			logger.Fatalf("Error parsing %s: %v", inputFile, err)
+		}
+
+		var cuts []splitPoint
+		switch {
+		case cmd.Flags().Changed("every"):
+			every, _ := cmd.Flags().GetDuration("every")
+			if every <= 0 {
+				logger.Fatal("--every must be positive")
+			}
+			step := uint32(every.Seconds() * float64(q.SampleRate))
+			for c := step; c < q.Samples && step > 0; c += step {
+				cuts = append(cuts, splitPoint{sample: c})
+			}
+		case cmd.Flags().Changed("frames"):
+			n, _ := cmd.Flags().GetInt("frames")
+			if n <= 0 {
+				logger.Fatal("--frames must be positive")
+			}
+			for c := uint64(n) * qoa.QOAFrameLen; c < uint64(q.Samples); c += uint64(n) * qoa.QOAFrameLen {
+				cuts = append(cuts, splitPoint{sample: uint32(c)})
+			}
+		case cmd.Flags().Changed("on-silence"):
+			spec, _ := cmd.Flags().GetString("on-silence")
+			threshold, minGap, err := parseSilenceSpec(spec)
+			if err != nil {
+				logger.Fatalf("Invalid --on-silence: %v", err)
+			}
+			_, decodedData, err := decodeQOA(data)
+			if err != nil {
+				logger.Fatalf("Error decoding %s: %v", inputFile, err)
+			}
+			cuts = findSilenceCuts(decodedData, q, threshold, minGap)
+		case cmd.Flags().Changed("chapters"):
+			chapterFile, _ := cmd.Flags().GetString("chapters")
+			cuts, err = readChapters(chapterFile, q.SampleRate)
+			if err != nil {
+				logger.Fatalf("Error reading chapters: %v", err)
+			}
+		}
+
+		if !exact {
+			for i := range cuts {
+				cuts[i].sample = uint32(math.Round(float64(cuts[i].sample)/qoa.QOAFrameLen)) * qoa.QOAFrameLen
+			}
+		}
+		segments := splitSegments(cuts, q.Samples)
+		name := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
+
+		for i, seg := range segments {
+			segFrames, reencoded, err := segmentFrames(frames, seg, q.SampleRate, q.Channels)
+			if err != nil {
+				logger.Fatalf("Error cutting piece %d: %v", i+1, err)
+			}
+
+			outputFile := expandSplitTemplate(template, name, i+1, seg, q.SampleRate)
+			if dir := filepath.Dir(outputFile); dir != "." {
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					logger.Fatalf("Error creating %s: %v", dir, err)
+				}
+			}
+			f, err := os.Create(outputFile)
+			if err != nil {
+				logger.Fatalf("Error creating %s: %v", outputFile, err)
+			}
+			err = writeQOAFrames(f, segFrames)
+			f.Close()
+			if err != nil {
+				logger.Fatalf("Error writing %s: %v", outputFile, err)
+			}
+
+			logger.Info(outputFile,
+				"start", formatDuration(samplesToDuration(seg.start, q.SampleRate)),
+				"length", formatDuration(samplesToDuration(seg.end-seg.start, q.SampleRate)),
+				"re-encoded frames", reencoded)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(splitCmd)
+	splitCmd.Flags().Duration("every", 0, "Cut every given duration, e.g. 30s")
+	splitCmd.Flags().Int("frames", 0, "Cut every N frames")
+	splitCmd.Flags().String("on-silence", "", "Cut in the middle of silences, given as threshold,min-gap, e.g. -50dB,500ms")
+	splitCmd.Flags().String("chapters", "", "Cut at the timestamps of a CUE or text chapter file")
+	splitCmd.MarkFlagsMutuallyExclusive("every", "frames", "on-silence", "chapters")
+	splitCmd.MarkFlagsOneRequired("every", "frames", "on-silence", "chapters")
+	splitCmd.Flags().StringP("output", "o", "{name}-{index}.qoa", "Output file name template")
+	splitCmd.Flags().Bool("exact", false, "Cut on the exact sample instead of the nearest frame boundary")
+}
+
+// splitPoint is where a new piece starts.
+type splitPoint struct {
+	// sample is the per-channel sample offset of the cut
+	sample uint32
+	// title names the piece starting here, if known
+	title string
+}
+
+// splitSegment is the sample range [start, end) of one output piece.
+type splitSegment struct {
+	start, end uint32
+	title      string
+}
+
+// splitSegments turns cut points into the pieces between them, dropping empty pieces.
+func splitSegments(cuts []splitPoint, totalSamples uint32) []splitSegment {
+	sort.SliceStable(cuts, func(i, j int) bool { return cuts[i].sample < cuts[j].sample })
+
+	segments := []splitSegment{{start: 0}}
+	for _, c := range cuts {
+		current := &segments[len(segments)-1]
+		if c.sample >= totalSamples {
+			break
+		}
+		if c.sample == current.start {
+			// A cut at the start of a piece only names it
+			if c.title != "" {
+				current.title = c.title
+			}
+			continue
+		}
+		current.end = c.sample
+		segments = append(segments, splitSegment{start: c.sample, title: c.title})
+	}
+	segments[len(segments)-1].end = totalSamples
+	return segments
+}
+
+// segmentFrames collects the frames covering seg. Every frame but the last of a file must be
+// full, so frames are copied verbatim only while the piece is still on frame boundaries. From
+// a cut inside a frame to the end of the piece, the samples are decoded and re-encoded.
+func segmentFrames(frames []qoaFrame, seg splitSegment, sampleRate, channels uint32) ([]qoaFrame, int, error) {
+	var out []qoaFrame
+	// pending holds decoded samples waiting to be re-encoded
+	var pending []int16
+	frameStart := uint32(0)
+	for _, f := range frames {
+		frameEnd := frameStart + f.samples
+		switch {
+		case frameEnd <= seg.start || frameStart >= seg.end:
+			// Not part of this piece
+		case pending == nil && frameStart >= seg.start && frameEnd <= seg.end:
+			out = append(out, f)
+		default:
+			samples, err := decodeQOAFrame(f)
+			if err != nil {
+				return nil, 0, err
+			}
+			from := max(frameStart, seg.start) - frameStart
+			to := min(frameEnd, seg.end) - frameStart
+			pending = append(pending, samples[from*channels:to*channels]...)
+		}
+		frameStart = frameEnd
+	}
+
+	if pending == nil {
+		return out, 0, nil
+	}
+	cut, err := encodeQOAFrames(pending, sampleRate, channels)
+	if err != nil {
+		return nil, 0, err
+	}
+	return append(out, cut...), len(cut), nil
+}
+
+// expandSplitTemplate fills in the placeholders of the output name template.
+func expandSplitTemplate(template, name string, index int, seg splitSegment, sampleRate uint32) string {
+	indexStr := fmt.Sprintf("%03d", index)
+	title := seg.title
+	if title == "" {
+		title = indexStr
+	}
+	// Titles come from chapter files, keep them from escaping the output directory
+	title = strings.NewReplacer("/", "_", "\\", "_").Replace(title)
+
+	return strings.NewReplacer(
+		"{name}", name,
+		"{index}", indexStr,
+		"{title}", title,
+		"{start}", formatDuration(samplesToDuration(seg.start, sampleRate)),
+	).Replace(template)
+}
+
+func samplesToDuration(samples, sampleRate uint32) time.Duration {
+	return time.Duration(int64(samples) * int64(time.Second) / int64(sampleRate))
+}
+
+// parseSilenceSpec parses "threshold,min-gap". The threshold is either in dBFS, e.g. -50dB, or a
+// linear amplitude between 0 and 1.
+func parseSilenceSpec(spec string) (float64, time.Duration, error) {
+	thresholdStr, gapStr, found := strings.Cut(spec, ",")
+	if !found {
+		return 0, 0, errors.New("expected threshold,min-gap")
+	}
+
+	var threshold float64
+	thresholdStr = strings.TrimSpace(thresholdStr)
+	if db, ok := strings.CutSuffix(strings.ToLower(thresholdStr), "db"); ok {
+		v, err := strconv.ParseFloat(db, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		threshold = math.Pow(10, v/20)
+	} else {
+		v, err := strconv.ParseFloat(thresholdStr, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		threshold = v
+	}
+	if threshold <= 0 || threshold > 1 {
+		return 0, 0, fmt.Errorf("threshold %s is out of range", thresholdStr)
+	}
+
+	minGap, err := time.ParseDuration(strings.TrimSpace(gapStr))
+	if err != nil {
+		return 0, 0, err
+	}
+	return threshold, minGap, nil
+}
+
+// findSilenceCuts returns a cut in the middle of every silence of at least minGap. A stretch is
+// silent if no sample on any channel is louder than threshold. Silences at the very start and end
+// aren't cut, since they'd produce silent pieces.
+func findSilenceCuts(decodedData []int16, q *qoa.QOA, threshold float64, minGap time.Duration) []splitPoint {
+	limit := int(threshold * 32768)
+	minRun := uint32(minGap.Seconds() * float64(q.SampleRate))
+	channels := int(q.Channels)
+	totalSamples := uint32(len(decodedData) / channels)
+
+	var cuts []splitPoint
+	runStart := uint32(0)
+	inRun := false
+	for i := uint32(0); i <= totalSamples; i++ {
+		silent := false
+		if i < totalSamples {
+			silent = true
+			for c := 0; c < channels; c++ {
+				s := int(decodedData[int(i)*channels+c])
+				if s > limit || -s > limit {
+					silent = false
+					break
+				}
+			}
+		}
+
+		switch {
+		case silent && !inRun:
+			runStart, inRun = i, true
+		case !silent && inRun:
+			inRun = false
+			if runStart > 0 && i < totalSamples && i-runStart >= minRun {
+				cuts = append(cuts, splitPoint{sample: runStart + (i-runStart)/2})
+			}
+		}
+	}
+	return cuts
+}
+
+// readChapters reads chapter start times from a CUE sheet or a plain text file.
+func readChapters(filename string, sampleRate uint32) ([]splitPoint, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(filepath.Ext(filename), ".cue") {
+		return parseCueChapters(lines, sampleRate)
+	}
+	return parseTextChapters(lines, sampleRate)
+}
+
+var cueTitleRegex = regexp.MustCompile(`^TITLE\s+"?(.*?)"?$`)
+
+// parseCueChapters reads the INDEX 01 entry of every track. CUE times are mm:ss:ff, with 75 frames a second.
+func parseCueChapters(lines []string, sampleRate uint32) ([]splitPoint, error) {
+	var chapters []splitPoint
+	inTrack := false
+	title := ""
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "TRACK":
+			inTrack, title = true, ""
+		case "TITLE":
+			if inTrack {
+				if m := cueTitleRegex.FindStringSubmatch(line); m != nil {
+					title = m[1]
+				}
+			}
+		case "INDEX":
+			if !inTrack || len(fields) < 3 || fields[1] != "01" {
+				continue
+			}
+			var mm, ss, ff int
+			if _, err := fmt.Sscanf(fields[2], "%d:%d:%d", &mm, &ss, &ff); err != nil {
+				return nil, fmt.Errorf("invalid CUE index %q", fields[2])
+			}
+			seconds := float64(mm*60+ss) + float64(ff)/75
+			chapters = append(chapters, splitPoint{sample: uint32(seconds * float64(sampleRate)), title: title})
+		}
+	}
+	if len(chapters) == 0 {
+		return nil, errors.New("no tracks found in CUE sheet")
+	}
+	return chapters, nil
+}
+
+// parseTextChapters reads "timestamp title" lines, ignoring blank lines and # comments.
+func parseTextChapters(lines []string, sampleRate uint32) ([]splitPoint, error) {
+	var chapters []splitPoint
+	for _, line := range lines {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		timestamp, title, _ := strings.Cut(line, " ")
+		start, err := parseTimestamp(timestamp)
+		if err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, splitPoint{
+			sample: uint32(start.Seconds() * float64(sampleRate)),
+			title:  strings.TrimSpace(title),
+		})
+	}
+	if len(chapters) == 0 {
+		return nil, errors.New("no chapters found")
+	}
+	return chapters, nil
+}
+
+// parseTimestamp parses [[hh:]mm:]ss[.fraction], e.g. 1:23.5
+func parseTimestamp(s string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	multiplier := 60.0
+	for i := len(parts) - 2; i >= 0; i-- {
+		v, err := strconv.Atoi(parts[i])
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		seconds += float64(v) * multiplier
+		multiplier *= 60
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
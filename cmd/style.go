@@ -11,11 +11,15 @@ const (
 
 	greenLight = "#56949f"
 	greenDark  = "#9ccfd8"
+
+	highlightLight = "#dfdad9"
+	highlightDark  = "#403d52"
 )
 
 var (
 	accent = lipgloss.AdaptiveColor{Dark: greenDark, Light: greenLight}
 	main   = lipgloss.AdaptiveColor{Dark: qoaPink, Light: qoaRed}
+	region = lipgloss.AdaptiveColor{Dark: highlightDark, Light: highlightLight}
 
 	listStyle = lipgloss.NewStyle().
 			Padding(1, 2).
@@ -0,0 +1,172 @@
+package cmd
+
+import "math"
+
+// wsola changes the speed of a track without changing its pitch, by waveform similarity
+// overlap-add. The output is built of overlapping segments of the track, taken a hop apart in
+// the output but speed hops apart in the track. Each segment is moved a little to where it
+// best lines up with the audio that followed the last one, so the overlaps don't smear or
+// cancel out.
+type wsola struct {
+	channels int
+	// hop is the output frames made at a time, half a segment
+	hop int
+	// search is how far a segment may move either side of where it belongs, in frames
+	search int
+	// window fades segments in and out. Overlapping halves add up to 1.
+	window []float64
+	// tail is the second half of the last segment, faded out, to overlap with the next one
+	tail []float64
+	// natural is where the audio that followed the last segment starts, or -1 for none
+	natural int64
+	// ready holds the output frames made, from readyPos on still to be read
+	ready    []float64
+	readyPos int
+	// cache holds frames of the track at normal speed from cacheStart
+	cache, spare []float64
+	cacheStart   int64
+	// mono and target are mixed down frames, for comparing segments
+	mono, target []float64
+}
+
+func newWSOLA(sampleRate, channels int) *wsola {
+	// 30ms segments, moving up to 5ms, suit speech and most music
+	hop := max(sampleRate*15/1000, 1)
+	w := &wsola{
+		channels: channels,
+		hop:      hop,
+		search:   sampleRate * 5 / 1000,
+		window:   make([]float64, 2*hop),
+		tail:     make([]float64, hop*channels),
+		natural:  -1,
+	}
+	for i := range w.window {
+		w.window[i] = 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(hop))
+	}
+	return w
+}
+
+// reset starts over, for when the track seeks or the speed changes.
+func (w *wsola) reset() {
+	w.natural = -1
+	w.ready = w.ready[:0]
+	w.readyPos = 0
+}
+
+// next computes the next output frame, which plays position at of s.
+func (w *wsola) next(s *pcmStream, at float64, out []float64) error {
+	if w.readyPos*w.channels >= len(w.ready) {
+		if err := w.overlapAdd(s, int64(math.Round(at))); err != nil {
+			return err
+		}
+	}
+	copy(out, w.ready[w.readyPos*w.channels:])
+	w.readyPos++
+	return nil
+}
+
+// overlapAdd makes a hop of output frames, from the segment that best fits near nominal.
+func (w *wsola) overlapAdd(s *pcmStream, nominal int64) error {
+	hop, ch := int64(w.hop), w.channels
+	start := nominal
+	if w.natural < 0 {
+		if err := w.load(s, start, start+2*hop); err != nil {
+			return err
+		}
+	} else {
+		search := int64(w.search)
+		if err := w.load(s, min(nominal-search, w.natural), max(nominal+search+2*hop, w.natural+hop)); err != nil {
+			return err
+		}
+		start = w.bestMatch(nominal)
+	}
+
+	segment := w.cache[(start-w.cacheStart)*int64(ch):]
+	w.ready = w.ready[:0]
+	for j := range w.hop {
+		for c := range ch {
+			v := segment[j*ch+c]
+			if w.natural >= 0 {
+				v = v*w.window[j] + w.tail[j*ch+c]
+			}
+			w.ready = append(w.ready, v)
+			w.tail[j*ch+c] = segment[(w.hop+j)*ch+c] * w.window[w.hop+j]
+		}
+	}
+	w.readyPos = 0
+	w.natural = start + hop
+	return nil
+}
+
+// bestMatch returns the start near nominal where the track is most like the audio that
+// followed the last segment.
+func (w *wsola) bestMatch(nominal int64) int64 {
+	// Channels are mixed down, and every other frame compared, to keep it quick
+	search := int64(w.search)
+	lo := nominal - search
+	w.mono = w.mono[:0]
+	for n := lo; n < nominal+search+int64(w.hop); n++ {
+		w.mono = append(w.mono, w.mixdown(n))
+	}
+	w.target = w.target[:0]
+	for j := int64(0); j < int64(w.hop); j += 2 {
+		w.target = append(w.target, w.mixdown(w.natural+j))
+	}
+
+	best, bestScore := nominal, math.Inf(-1)
+	for start := max(lo, 0); start <= nominal+search; start++ {
+		candidate := w.mono[start-lo:]
+		corr, energy := 0.0, 0.0
+		for i, t := range w.target {
+			v := candidate[i*2]
+			corr += v * t
+			energy += v * v
+		}
+		score := 0.0
+		if energy > 0 {
+			score = corr / math.Sqrt(energy)
+		}
+		// Ties go to the start nearest where the segment belongs
+		if score > bestScore || (score == bestScore && abs(start-nominal) < abs(best-nominal)) {
+			best, bestScore = start, score
+		}
+	}
+	return best
+}
+
+func (w *wsola) mixdown(n int64) float64 {
+	frame := w.cache[(n-w.cacheStart)*int64(w.channels):]
+	v := 0.0
+	for c := range w.channels {
+		v += frame[c]
+	}
+	return v
+}
+
+// load makes sure the cache holds the frames of the track from lo up to hi, keeping what it
+// already has of them.
+func (w *wsola) load(s *pcmStream, lo, hi int64) error {
+	ch := int64(w.channels)
+	end := w.cacheStart + int64(len(w.cache))/ch
+	if w.cache != nil && lo >= w.cacheStart && hi <= end {
+		return nil
+	}
+
+	size := max(hi-lo, streamWindowFrames)
+	if int64(cap(w.spare)) < size*ch {
+		w.spare = make([]float64, size*ch)
+	}
+	cache := w.spare[:size*ch]
+	for n := lo; n < lo+size; n++ {
+		frame := cache[(n-lo)*ch : (n-lo+1)*ch]
+		if w.cache != nil && n >= w.cacheStart && n < end {
+			copy(frame, w.cache[(n-w.cacheStart)*ch:])
+			continue
+		}
+		if err := s.resample(n, frame); err != nil {
+			return err
+		}
+	}
+	w.cache, w.spare, w.cacheStart = cache, w.cache, lo
+	return nil
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"strings"
 	"time"
@@ -12,9 +13,9 @@ import (
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/ebitengine/oto/v3"
 )
 
 // ==========================================
@@ -23,9 +24,12 @@ import (
 // tickMsg is sent periodically to update the progress bar.
 type tickMsg time.Time
 
+// tickInterval is how often the progress and visualizer update.
+const tickInterval = 50 * time.Millisecond
+
 // tickCmd is a helper function to create a tickMsg.
 func tickCmd() tea.Cmd {
-	return tea.Tick(time.Millisecond*100, func(t time.Time) tea.Msg {
+	return tea.Tick(tickInterval, func(t time.Time) tea.Msg {
 		return tickMsg(t)
 	})
 }
@@ -43,8 +47,8 @@ type model struct {
 	currentIndex int
 	// qoaPlayer is the QOA player
 	qoaPlayer *qoaPlayer
-	// ctx is the Oto context. There can only be one per process.
-	ctx *oto.Context
+	// output is where the audio goes. There can only be one per process.
+	output audioOutput
 	// help is the help bubble model
 	help help.Model
 	// To support help
@@ -56,6 +60,25 @@ type model struct {
 	terminalHeight int
 	// whether to autoplay or not
 	autoplay bool
+	// songs is the playlist, with the order songs play in
+	songs *playlist
+	// status is a message about the last thing done, like saving the playlist
+	status string
+	// heard is the track last heard, to notice when the next one starts
+	heard *track
+	// prompt asks for a time to go to, while prompting is set
+	prompt    textinput.Model
+	prompting bool
+	// visualizer shows the levels or spectrum of what is heard
+	visualizer *visualizer
+	// overviews are the waveform overviews of the songs
+	overviews *overviews
+	// waveform is the overview of the song heard fitted to the screen, once it's ready,
+	// and waveformOf the file it is of
+	waveform   []int
+	waveformOf string
+	// scrub is the waveform column the mouse is dragging the cursor to, or -1
+	scrub int
 }
 
 type item struct {
@@ -66,49 +89,26 @@ func (i item) Title() string       { return i.title }
 func (i item) Description() string { return i.desc }
 func (i item) FilterValue() string { return i.title }
 
-// qoaPlayer handles playing QOA audio files and showing progress.
+// qoaPlayer handles playing QOA audio files and showing progress. It plays one track after
+// another through a single player, so the next track can start without a gap.
 type qoaPlayer struct {
-	// qoaData is the raw QOA encoded audio bytes.
-	qoaData []int16
-	// player is the Oto player, which does the actually playing of sound.
-	player *oto.Player
-	// qoaMetadata is the QOA encoder struct.
-	qoaMetadata qoa.QOA
-	// totalLength is the total length of the song.
-	totalLength time.Duration
-	// filename is the filename of the song being played.
-	filename string
-	// reader is a pointer to the QOA reader, so we can track song position better.
+	// track is the song being heard. It changes on its own when the next song starts.
+	*track
+	// player does the actual playing of sound.
+	player audioPlayer
+	// queue feeds the player the songs in turn. It is kept so we can track song position better.
 	// oto doesn't support getting the player position while it's playing but might one day:
 	// https://github.com/ebitengine/oto/issues/228
-	reader         *qoa.Reader
+	queue          *trackQueue
+	output         audioOutput
+	settings       *playerSettings
 	currentSeconds float64
 	samplesPlayed  int
-	bitrate        uint32
 }
 
-// initialModel creates a new model with the given filenames.
-func initialModel(filenames []string) *model {
-	// peek the first file to get audio context info
-	qoaBytes := openFile(filenames[0])
-	qoaMetadata, err := qoa.DecodeHeader(qoaBytes)
-	if err != nil {
-		logger.Fatalf("Error decoding QOA header: %v", err)
-	}
-	// Prepare an Oto context (this will use the default audio device)
-	ctx, ready, err := oto.NewContext(
-		&oto.NewContextOptions{
-			// Typically 44100 or 48000, we could get it from a QOA file but we'd have to decode one.
-			SampleRate: int(qoaMetadata.SampleRate),
-			// only 1 or 2 are supported by oto
-			ChannelCount: int(qoaMetadata.Channels),
-			// QOA is always 16 bit
-			Format: oto.FormatSignedInt16LE,
-		})
-	if err != nil {
-		panic("oto.NewContext failed: " + err.Error())
-	}
-
+// initialModel creates a new model with the given filenames, playing to output in order.
+// settings may be nil for the defaults.
+func initialModel(songs *playlist, output audioOutput, settings *playerSettings) *model {
 	// Create the help bubble
 	help := help.New()
 	help.ShowAll = true
@@ -118,15 +118,14 @@ func initialModel(filenames []string) *model {
 	prog.ShowPercentage = false
 	prog.Width = maxWidth
 
-	items := make([]list.Item, len(filenames))
-	for i, filename := range filenames {
-		qoaBytes := openFile(filename)
-		qoaMetadata, err := qoa.DecodeHeader(qoaBytes)
+	items := make([]list.Item, len(songs.filenames))
+	for i, filename := range songs.filenames {
+		qoaMetadata, err := readQOAHeader(filename)
 		if err != nil {
 			logger.Fatalf("Error decoding QOA header: %v", err)
 		}
 		desc := formatDuration(calcSongLength(qoaMetadata))
-		items[i] = item{title: filename, desc: desc}
+		items[i] = item{title: songs.title(i), desc: desc}
 	}
 	delegate := list.NewDefaultDelegate()
 	delegate.Styles.SelectedTitle = delegate.Styles.SelectedTitle.Foreground(main)
@@ -137,63 +136,123 @@ func initialModel(filenames []string) *model {
 	listModel.InfiniteScrolling = true
 	listModel.Styles.Title = listTitleStyle
 
-	// Wait for the audio context to be ready
-	<-ready
+	prompt := textinput.New()
+	prompt.Prompt = "Go to: "
+	prompt.Placeholder = "1:23.5"
+	prompt.CharLimit = 16
 
 	m := &model{
-		filenames:    filenames,
+		filenames:    songs.filenames,
 		fileList:     listModel,
 		currentIndex: -1,
-		ctx:          ctx,
+		output:       output,
+		qoaPlayer:    newQOAPlayer(output),
 		help:         help,
 		keys:         helpKeys,
 		progress:     prog,
 		autoplay:     true,
+		songs:        songs,
+		prompt:       prompt,
+		visualizer:   newVisualizer(output.SampleRate(), output.ChannelCount()),
+		overviews:    newOverviews(),
+		scrub:        -1,
 	}
 
-	m.nextSong()
+	m.qoaPlayer.useSettings(settings)
+	m.loadSong(songs.order.current())
+	m.qoaPlayer.useLoopSettings()
 
 	return m
 }
 
-func openFile(filename string) []byte {
-	_, err := qoa.IsValidQOAFile(filename)
-	if err != nil {
-		logger.Fatalf("Error validating QOA file: %v", err)
+// newQOAPlayer creates a new QOA player playing to output. It needs a song loaded to play.
+func newQOAPlayer(output audioOutput) *qoaPlayer {
+	queue := newTrackQueue(output.ChannelCount())
+	return &qoaPlayer{
+		player:   output.NewPlayer(queue),
+		queue:    queue,
+		output:   output,
+		settings: defaultPlayerSettings(),
 	}
+}
 
-	qoaBytes, err := os.ReadFile(filename)
-	if err != nil {
-		logger.Fatalf("Error reading QOA file: %v", err)
+// useSettings plays with the volume of the given settings, and keeps them up to date.
+func (qp *qoaPlayer) useSettings(settings *playerSettings) {
+	if settings != nil {
+		qp.settings = settings
 	}
-
-	return qoaBytes
+	qp.player.SetVolume(qp.settings.gain())
+	if qp.settings.speed == 0 {
+		// Settings made without a speed play at normal speed
+		qp.settings.speed = 1
+	}
+	qp.queue.setSpeed(qp.settings.speed, qp.settings.varispeed)
 }
 
-// newQOAPlayer creates a new QOA player for the given filename.
-func newQOAPlayer(filename string, ctx *oto.Context) *qoaPlayer {
-	qoaBytes := openFile(filename)
-	qoaMetadata, qoaAudioData, err := qoa.Decode(qoaBytes)
-	if err != nil {
-		logger.Fatalf("Error decoding QOA data: %v", err)
+// useLoopSettings loops the song loaded, if the settings have a loop.
+func (qp *qoaPlayer) useLoopSettings() {
+	if qp.settings.loop {
+		qp.loopBetween(qp.settings.loopStart, qp.settings.loopEnd)
 	}
+}
 
-	// Calculate length of song in nanoseconds
-	totalLength := calcSongLength(qoaMetadata)
+// changeSpeed speeds playback up or down by delta, from what is read next.
+func (qp *qoaPlayer) changeSpeed(delta float64) {
+	// Rounding keeps repeated steps from drifting off the tenths
+	qp.settings.speed = max(minSpeed, min(math.Round((qp.settings.speed+delta)*10)/10, maxSpeed))
+	qp.queue.setSpeed(qp.settings.speed, qp.settings.varispeed)
+}
 
-	reader := qoa.NewReader(qoaAudioData, int(qoaMetadata.Channels))
-	player := ctx.NewPlayer(reader)
-	bitrate := (qoaMetadata.SampleRate * qoaMetadata.Channels * 16) / 1000
+// toggleVarispeed switches between keeping the pitch when the speed changes and letting it
+// change with the speed, like a tape.
+func (qp *qoaPlayer) toggleVarispeed() {
+	qp.settings.varispeed = !qp.settings.varispeed
+	qp.queue.setSpeed(qp.settings.speed, qp.settings.varispeed)
+}
 
-	return &qoaPlayer{
-		filename:    filename,
-		qoaData:     qoaAudioData,
-		qoaMetadata: *qoaMetadata,
-		player:      player,
-		totalLength: totalLength,
-		reader:      reader,
-		bitrate:     bitrate,
+// changeVolume turns the volume up or down by delta, unmuting, and saves it for next time.
+func (qp *qoaPlayer) changeVolume(delta float64) error {
+	qp.settings.Volume = clampVolume(qp.settings.Volume + delta)
+	qp.settings.Muted = false
+	qp.player.SetVolume(qp.settings.gain())
+	return qp.settings.save()
+}
+
+// toggleMute mutes or unmutes, and saves it for next time.
+func (qp *qoaPlayer) toggleMute() error {
+	qp.settings.Muted = !qp.settings.Muted
+	qp.player.SetVolume(qp.settings.gain())
+	return qp.settings.save()
+}
+
+// load switches to the song at index of the playlist right away.
+func (qp *qoaPlayer) load(index int, filename string) {
+	t, err := loadTrack(index, filename, qp.output)
+	if err != nil {
+		logger.Fatalf("Error loading %s: %v", filename, err)
+	}
+	qp.queue.play(t)
+	// Drop what the player buffered of the last song
+	qp.player.Seek(0, io.SeekStart)
+	qp.track = t
+	qp.currentSeconds, qp.samplesPlayed = 0, 0
+}
+
+// queueNext loads the song at index of the playlist in the background, to play right after
+// the current one. An index of -1 clears what is up next.
+func (qp *qoaPlayer) queueNext(index int, filename string) {
+	generation := qp.queue.queue()
+	if index < 0 {
+		return
 	}
+	go func() {
+		// If loading fails, playback stops at the end of the current song, and loading
+		// it again from there reports the error
+		t, err := loadTrack(index, filename, qp.output)
+		if err == nil {
+			qp.queue.setNext(t, generation)
+		}
+	}()
 }
 
 func calcSongLength(qoaMetadata *qoa.QOA) time.Duration {
@@ -214,51 +273,166 @@ func (qp *qoaPlayer) togglePlayPause() tea.Cmd {
 	}
 }
 
-// getPlayerProgress returns the current progress of the player in percent.
+// getPlayerProgress returns the current progress of the player in percent. It also moves
+// on to the song being heard, when the next one has started.
 func (qp *qoaPlayer) getPlayerProgress() float64 {
-	if qp.totalLength == 0 {
+	t, framesPlayed := qp.queue.heard(qp.player.BufferedSize())
+	qp.track = t
+	length := t.stream.Length()
+	if length == 0 {
 		return 0
 	}
 
-	// Calculate number of samples in buffer
-	// Multiple by 2 for 16-bit samples
-	bufferedSamples := float64(qp.player.BufferedSize()) / (float64(qp.qoaMetadata.Channels) * 2.0)
-
-	// Calculate the actual samples played
-	samplesPlayed := float64(qp.reader.Position()) - bufferedSamples
-
-	if samplesPlayed < 0 {
-		samplesPlayed = 0
-	}
-
-	// Calculate newPercent based on samples
-	totalSamples := float64(qp.qoaMetadata.Samples)
-	newPercent := samplesPlayed / totalSamples
-	if samplesPlayed >= totalSamples {
+	newPercent := float64(framesPlayed) / float64(length)
+	if framesPlayed >= length {
 		newPercent = 1.0
 	}
 
-	// Update currentSeconds for potential other uses, calculated from samplesPlayed
-	qp.currentSeconds = time.Duration((int64(samplesPlayed) * int64(time.Second)) / int64(qp.qoaMetadata.SampleRate)).Seconds()
-	qp.samplesPlayed = int(samplesPlayed)
+	// Update currentSeconds for potential other uses. samplesPlayed is in the song's own sample rate
+	qp.currentSeconds = float64(framesPlayed) / float64(t.stream.sampleRate)
+	qp.samplesPlayed = int(float64(framesPlayed) * t.stream.ratio)
 
 	return newPercent
 }
 
-// seekForward moves the player forward by 5 seconds.
+// ended reports whether the player stopped at the end of the last song queued.
+func (qp *qoaPlayer) ended() bool {
+	return qp.getPlayerProgress() >= 1.0 && !qp.player.IsPlaying()
+}
+
+// position returns how far into the song the player is.
+func (qp *qoaPlayer) position() time.Duration {
+	qp.getPlayerProgress()
+	return time.Duration(qp.currentSeconds * float64(time.Second))
+}
+
+// seekForward moves the player forward by the seek forward step, 5 seconds by default.
 func (qp *qoaPlayer) seekForward() float64 {
-	return qp.seekRelative(5 * time.Second)
+	return qp.seekRelative(qp.settings.seekForward)
 }
 
-// seekBack moves the player back by 7 seconds.
+// seekBack moves the player back by the seek back step, 7 seconds by default.
 func (qp *qoaPlayer) seekBack() float64 {
-	return qp.seekRelative(-7 * time.Second)
+	return qp.seekRelative(-qp.settings.seekBack)
 }
 
 // seekRelative moves the player by the given delta and returns the new progress percent.
 func (qp *qoaPlayer) seekRelative(delta time.Duration) float64 {
-	sampleOffset := int64(delta.Seconds() * float64(qp.qoaMetadata.SampleRate))
-	qp.player.Seek(sampleOffset, io.SeekCurrent)
+	// Seek from what is being heard, since the player drops what it has buffered
+	frame := qp.heardFrame()
+	return qp.seekToFrame(frame + int64(math.Round(delta.Seconds()*float64(qp.stream.sampleRate))))
+}
+
+// seekTo moves the player to the given time in the song and returns the new progress percent.
+func (qp *qoaPlayer) seekTo(position time.Duration) float64 {
+	qp.getPlayerProgress()
+	return qp.seekToFrame(qp.frameAt(position))
+}
+
+// heardFrame returns the output frame being heard, moving on to the song it's in.
+func (qp *qoaPlayer) heardFrame() int64 {
+	t, frame := qp.queue.heard(qp.player.BufferedSize())
+	qp.track = t
+	return frame
+}
+
+// frameAt is the output frame of the song's own sample at position, so seeks and loops land
+// on exact samples.
+func (qp *qoaPlayer) frameAt(position time.Duration) int64 {
+	sample := math.Round(position.Seconds() * float64(qp.qoaMetadata.SampleRate))
+	return int64(math.Round(sample / qp.stream.ratio))
+}
+
+// seekToPercent moves the player to the given fraction of the song and returns the new progress percent.
+func (qp *qoaPlayer) seekToPercent(percent float64) float64 {
+	qp.getPlayerProgress()
+	sample := math.Round(percent * float64(qp.qoaMetadata.Samples))
+	return qp.seekToFrame(int64(math.Round(sample / qp.stream.ratio)))
+}
+
+// setLoopStart starts the loop at the position heard. If that's past the end of the loop,
+// the loop ends at the end of the song.
+func (qp *qoaPlayer) setLoopStart() {
+	frame := qp.heardFrame()
+	_, end, looping := qp.stream.loop()
+	if end <= frame {
+		end = qp.stream.Length()
+	}
+	qp.setLoop(frame, end, looping)
+}
+
+// setLoopEnd ends the loop at the position heard. If that's before the start of the loop, the
+// loop starts at the start of the song.
+func (qp *qoaPlayer) setLoopEnd() {
+	frame := qp.heardFrame()
+	start, _, looping := qp.stream.loop()
+	if frame <= start {
+		start = 0
+	}
+	qp.setLoop(start, frame, looping)
+}
+
+// toggleLoop starts or stops looping. Until loop points are set, the whole song loops.
+func (qp *qoaPlayer) toggleLoop() {
+	qp.heardFrame()
+	start, end, looping := qp.stream.loop()
+	qp.setLoop(start, end, !looping)
+}
+
+// loopBetween loops the song from start to end, or to the end of the song if end is 0, and
+// goes to the start of the loop.
+func (qp *qoaPlayer) loopBetween(start, end time.Duration) {
+	qp.heardFrame()
+	endFrame := qp.stream.Length()
+	if end > 0 {
+		endFrame = qp.frameAt(end)
+	}
+	qp.stream.setLoop(qp.frameAt(start), endFrame, true)
+	startFrame, _, _ := qp.stream.loop()
+	qp.seekToFrame(startFrame)
+}
+
+// setLoop sets the loop of the song heard. When looping, what the player buffered is read
+// again, so the loop applies from what is heard.
+func (qp *qoaPlayer) setLoop(start, end int64, looping bool) {
+	frame := qp.heardFrame()
+	qp.stream.setLoop(start, end, looping)
+	if looping {
+		qp.seekToFrame(frame)
+	}
+}
+
+// describeLoop returns the loop region, for showing to the user.
+func (qp *qoaPlayer) describeLoop() string {
+	start, end, looping := qp.stream.loop()
+	if !looping && start == 0 && end == qp.stream.Length() {
+		return "off"
+	}
+	rate := float64(qp.stream.sampleRate)
+	region := fmt.Sprintf("%.2fs-%.2fs", float64(start)/rate, float64(end)/rate)
+	if !looping {
+		region += " (off)"
+	}
+	return region
+}
+
+// restartAfter is how far into a song going to the previous song restarts it instead.
+const restartAfter = 3 * time.Second
+
+// restart goes back to the start of the song, if more than restartAfter of it has played.
+func (qp *qoaPlayer) restart() bool {
+	if qp.position() <= restartAfter {
+		return false
+	}
+	qp.seekToFrame(0)
+	return true
+}
+
+// seekToFrame moves the player to an output frame of the song being heard.
+func (qp *qoaPlayer) seekToFrame(frame int64) float64 {
+	qp.queue.rewind(qp.track)
+	frame = max(0, min(frame, qp.stream.Length()))
+	qp.player.Seek(frame*int64(qp.stream.channels*2), io.SeekStart)
 	return qp.getPlayerProgress()
 }
 
@@ -266,8 +440,8 @@ func (qp *qoaPlayer) seekRelative(delta time.Duration) float64 {
 // ================= Main ===================
 // ==========================================
 // startTUI is the main entry point for the TUI.
-func startTUI(inputFiles []string) {
-	p := tea.NewProgram(initialModel(inputFiles), tea.WithAltScreen())
+func startTUI(songs *playlist, output audioOutput, settings *playerSettings) {
+	p := tea.NewProgram(initialModel(songs, output, settings), tea.WithAltScreen(), tea.WithMouseCellMotion())
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Alas, there's been an error: %v", err)
 		os.Exit(1)
@@ -294,9 +468,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			listHeight = msg.Height
 		}
 		m.fileList.SetSize(msg.Width/3, listHeight)
+		m.updateWaveform(true)
 		return m, m.checkRepaint(msg)
-	// Handle key presses
+	// While asking for a time, keys go to the prompt
 	case tea.KeyMsg:
+		if m.prompting {
+			return m.updatePrompt(msg)
+		}
 		switch {
 		case key.Matches(msg, m.keys.quit):
 			if m.qoaPlayer.player.IsPlaying() {
@@ -312,19 +490,101 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, m.keys.seekBack):
 			newPercent := m.qoaPlayer.seekBack()
 			return m, m.progress.SetPercent(newPercent)
+		case key.Matches(msg, m.keys.jump):
+			newPercent := m.qoaPlayer.seekToPercent(float64(msg.Runes[0]-'0') / 10)
+			return m, m.progress.SetPercent(newPercent)
+		case key.Matches(msg, m.keys.stepBack):
+			return m, m.progress.SetPercent(m.qoaPlayer.seekToPercent(m.columnPercent(m.playedColumn() - 1)))
+		case key.Matches(msg, m.keys.stepForward):
+			return m, m.progress.SetPercent(m.qoaPlayer.seekToPercent(m.columnPercent(m.playedColumn() + 1)))
+		case key.Matches(msg, m.keys.goTo):
+			m.prompting = true
+			m.prompt.Reset()
+			return m, m.prompt.Focus()
+		case key.Matches(msg, m.keys.nextSong):
+			if next, ok := m.songs.order.next(false); ok {
+				m.loadSong(next)
+			}
+			return m, m.progress.SetPercent(0)
+		case key.Matches(msg, m.keys.previousSong):
+			if !m.qoaPlayer.restart() {
+				m.loadSong(m.songs.order.previous())
+			}
+			return m, m.progress.SetPercent(0)
 		case key.Matches(msg, m.keys.pickSong):
 			m.loadSong(m.fileList.Index())
 		case key.Matches(msg, m.keys.toggleAutoplay):
 			m.autoplay = !m.autoplay
+			m.queueNextSong()
+		case key.Matches(msg, m.keys.toggleShuffle):
+			m.songs.order.setShuffle(!m.songs.order.shuffle)
+			m.queueNextSong()
+		case key.Matches(msg, m.keys.cycleRepeat):
+			m.songs.order.cycleRepeat()
+			m.queueNextSong()
+		case key.Matches(msg, m.keys.volumeUp):
+			m.setStatus(m.qoaPlayer.changeVolume(volumeStep))
+		case key.Matches(msg, m.keys.volumeDown):
+			m.setStatus(m.qoaPlayer.changeVolume(-volumeStep))
+		case key.Matches(msg, m.keys.mute):
+			m.setStatus(m.qoaPlayer.toggleMute())
+		case key.Matches(msg, m.keys.speedUp):
+			m.qoaPlayer.changeSpeed(speedStep)
+		case key.Matches(msg, m.keys.speedDown):
+			m.qoaPlayer.changeSpeed(-speedStep)
+		case key.Matches(msg, m.keys.toggleVarispeed):
+			m.qoaPlayer.toggleVarispeed()
+		case key.Matches(msg, m.keys.setLoopStart):
+			m.qoaPlayer.setLoopStart()
+		case key.Matches(msg, m.keys.setLoopEnd):
+			m.qoaPlayer.setLoopEnd()
+		case key.Matches(msg, m.keys.toggleLoop):
+			m.qoaPlayer.toggleLoop()
+		case key.Matches(msg, m.keys.visualize):
+			m.visualizer.toggle()
+		case key.Matches(msg, m.keys.savePlaylist):
+			if err := m.songs.save(); err != nil {
+				m.status = fmt.Sprintf("Error saving playlist: %v", err)
+			} else {
+				m.status = "Saved playlist to " + m.songs.saveAs
+			}
+		}
+	// Dragging along the waveform moves a cursor, and letting go seeks to it
+	case tea.MouseMsg:
+		switch {
+		case msg.Action == tea.MouseActionRelease && m.scrub >= 0:
+			percent := m.columnPercent(m.scrub)
+			m.scrub = -1
+			return m, m.progress.SetPercent(m.qoaPlayer.seekToPercent(percent))
+		case msg.Button != tea.MouseButtonLeft:
+		case msg.Action == tea.MouseActionPress:
+			if column, ok := m.waveformAt(msg.X, msg.Y); ok {
+				m.scrub = column
+				return m, nil
+			}
+		case msg.Action == tea.MouseActionMotion && m.scrub >= 0:
+			column, _ := m.waveformAt(msg.X, msg.Y)
+			m.scrub = column
+			return m, nil
 		}
 	// Update the progress. This is called periodically, so also handle songs that are over.
 	case tickMsg:
-		if m.progress.Percent() >= 1.0 && m.autoplay {
-			m.nextSong()
+		percentDone := m.qoaPlayer.getPlayerProgress()
+		m.updateVisualizer(time.Time(msg))
+		m.updateWaveform(false)
+		if m.qoaPlayer.track != m.heard {
+			// The next song started playing right after the last
+			m.heard = m.qoaPlayer.track
+			m.currentIndex = m.qoaPlayer.index
+			m.songs.order.moveTo(m.currentIndex)
+			m.fileList.Select(m.currentIndex)
+			m.queueNextSong()
+		}
+		if m.qoaPlayer.ended() && m.autoplay && m.nextSong() {
+			// The next song wasn't loaded in time
 			cmd := m.progress.SetPercent(0.0)
 			return m, tea.Batch(tickCmd(), cmd)
 		} else {
-			percentDone := m.qoaPlayer.getPlayerProgress()
 			cmd := m.progress.SetPercent(percentDone)
 			// Set new progress bar percent and keep ticking
 			return m, tea.Batch(cmd, tickCmd())
@@ -341,24 +601,89 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
-func (m *model) loadSong(index int) {
-	if m.qoaPlayer != nil {
-		m.qoaPlayer.player.Close()
+// updatePrompt handles a key pressed while asking for a time to go to.
+func (m model) updatePrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.prompting = false
+		m.prompt.Blur()
+		position, err := parseTimestamp(m.prompt.Value())
+		if err != nil {
+			m.status = err.Error()
+			return m, nil
+		}
+		m.status = ""
+		return m, m.progress.SetPercent(m.qoaPlayer.seekTo(position))
+	case tea.KeyEsc, tea.KeyCtrlC:
+		m.prompting = false
+		m.prompt.Blur()
+		return m, nil
 	}
-	nextFile := m.filenames[index]
+	var cmd tea.Cmd
+	m.prompt, cmd = m.prompt.Update(msg)
+	return m, cmd
+}
 
-	// Create a new QOA player for the next song
-	m.qoaPlayer = newQOAPlayer(nextFile, m.ctx)
+func (m *model) loadSong(index int) {
+	m.qoaPlayer.load(index, m.filenames[index])
 	m.qoaPlayer.player.Play()
+	m.heard = m.qoaPlayer.track
 	m.currentIndex = index
+	m.songs.order.moveTo(index)
 	m.fileList.Select(m.currentIndex)
+	m.queueNextSong()
+	m.updateWaveform(false)
+}
+
+// queueNextSong gets the song after the current one ready to play without a gap, when autoplaying.
+func (m *model) queueNextSong() {
+	nextIndex, ok := m.songs.order.next(true)
+	if !m.autoplay || !ok {
+		m.qoaPlayer.queueNext(-1, "")
+		return
+	}
+	m.qoaPlayer.queueNext(nextIndex, m.filenames[nextIndex])
+}
+
+// nextSong changes to the next song in the play order. It reports false at the end of the playlist.
+func (m *model) nextSong() bool {
+	nextIndex, ok := m.songs.order.next(true)
+	if ok {
+		m.loadSong(nextIndex)
+	}
+	return ok
 }
 
-// nextSong changes to the next song in the filenames list, wrapping around to 0 if needed.
-func (m *model) nextSong() {
-	// Select next song in filenames list, but wrap around to 0 if at end
-	nextIndex := (m.currentIndex + 1) % len(m.filenames)
-	m.loadSong(nextIndex)
+// updateWaveform fits the overview of the song heard to the width of the screen, once it's
+// ready. It is fitted again when the screen is resized.
+func (m *model) updateWaveform(resized bool) {
+	filename := m.qoaPlayer.filename
+	if filename != m.waveformOf {
+		m.waveform, m.waveformOf = nil, filename
+	}
+	if m.waveform != nil && !resized {
+		return
+	}
+	if overview, ok := m.overviews.get(filename); ok {
+		m.waveform = waveformColumns(overview, m.progress.Width)
+	}
+}
+
+// updateVisualizer shows the samples being heard, or silence when paused.
+func (m *model) updateVisualizer(now time.Time) {
+	v := m.visualizer
+	frames := 0
+	if m.qoaPlayer.player.IsPlaying() {
+		frames = m.qoaPlayer.queue.heardSamples(m.qoaPlayer.player.BufferedSize(), v.samples)
+	}
+	v.update(v.samples[:frames*v.channels], now)
+}
+
+// setStatus shows an error saving the settings, if there was one.
+func (m *model) setStatus(err error) {
+	if err != nil {
+		m.status = fmt.Sprintf("Error saving settings: %v", err)
+	}
 }
 
 func (m *model) checkRepaint(msg tea.WindowSizeMsg) tea.Cmd {
@@ -385,6 +710,15 @@ func (m *model) checkRepaint(msg tea.WindowSizeMsg) tea.Cmd {
 // ==========================================
 // View renders the current state of the application.
 func (m model) View() string {
+	fileListView := listStyle.Render(m.fileList.View())
+
+	view := lipgloss.JoinHorizontal(lipgloss.Top, fileListView, m.renderMain())
+
+	return lipgloss.PlaceHorizontal(m.terminalWidth, lipgloss.Center, view)
+}
+
+// renderMain renders everything but the song list.
+func (m model) renderMain() string {
 	var mainView strings.Builder
 
 	mainView.WriteString(m.renderTitle())
@@ -395,19 +729,64 @@ func (m model) View() string {
 	mainView.WriteRune('\n')
 
 	// Song progress
-	mainView.WriteString(m.progress.View())
-	mainView.WriteString(m.renderTime())
+	mainView.WriteString(lipgloss.JoinHorizontal(lipgloss.Bottom, m.renderWaveform(), m.renderTime()))
 	mainView.WriteRune('\n')
 
+	if m.prompting {
+		mainView.WriteString(m.prompt.View())
+	}
 	mainView.WriteRune('\n')
+	mainView.WriteString(m.visualizer.render(m.progress.Width))
+	mainView.WriteString("\n\n")
 	mainView.WriteString(m.help.View(m.keys))
 	mainView.WriteRune('\n')
 
-	fileListView := listStyle.Render(m.fileList.View())
+	return mainView.String()
+}
+
+// renderWaveform draws the song's waveform in place of a progress bar. Until the overview is
+// ready, it's drawn flat.
+func (m model) renderWaveform() string {
+	columns := m.waveform
+	if columns == nil {
+		columns = waveformColumns(nil, m.progress.Width)
+	}
+	// The loop region is marked, once it's more than the whole song
+	regionStart, regionEnd := -1, -1
+	start, end, looping := m.qoaPlayer.stream.loop()
+	if length := m.qoaPlayer.stream.Length(); looping || start > 0 || end < length {
+		last := float64(max(m.progress.Width-1, 1))
+		regionStart = int(math.Round(float64(start) / float64(length) * last))
+		regionEnd = int(math.Round(float64(end) / float64(length) * last))
+	}
+	return drawWaveform(columns, m.progress.Percent(), m.scrub, regionStart, regionEnd)
+}
 
-	view := lipgloss.JoinHorizontal(lipgloss.Top, fileListView, mainView.String())
+// columnPercent is how far through the song column of the waveform is, the first column
+// being the start and the last the end.
+func (m model) columnPercent(column int) float64 {
+	column = max(0, min(column, m.progress.Width-1))
+	return float64(column) / float64(max(m.progress.Width-1, 1))
+}
 
-	return lipgloss.PlaceHorizontal(m.terminalWidth, lipgloss.Center, view)
+// playedColumn is the waveform column of the position heard.
+func (m model) playedColumn() int {
+	return int(math.Round(m.qoaPlayer.getPlayerProgress() * float64(max(m.progress.Width-1, 1))))
+}
+
+// waveformAt returns the waveform column at the screen cell x, y, laid out the way View lays
+// it out. It reports false if the cell isn't on the waveform, returning the nearest column.
+func (m model) waveformAt(x, y int) (int, bool) {
+	top := lipgloss.Height(m.renderTitle()) + lipgloss.Height(m.renderStats())
+	listWidth := lipgloss.Width(listStyle.Render(m.fileList.View()))
+	left := listWidth
+	// View centers the song list and the rest together
+	if gap := m.terminalWidth - listWidth - lipgloss.Width(m.renderMain()); gap > 0 {
+		left += gap - int(math.Round(float64(gap)*0.5))
+	}
+	column := x - left
+	onWaveform := y >= top && y < top+waveformHeight && column >= 0 && column < m.progress.Width
+	return max(0, min(column, m.progress.Width-1)), onWaveform
 }
 
 func formatDuration(d time.Duration) string {
@@ -430,7 +809,7 @@ func (m model) renderTitle() string {
 		Bold(true).
 		Foreground(accent)
 
-	title := playingStyle.Render("Playing:") + " " + songStyle.Render(m.qoaPlayer.filename)
+	title := playingStyle.Render("Playing:") + " " + songStyle.Render(m.songs.title(m.qoaPlayer.index))
 	view := lipgloss.NewStyle().Padding(1).Render(title)
 	return view
 }
@@ -439,10 +818,17 @@ func (m model) renderStats() string {
 		Faint(true).
 		PaddingBottom(1)
 
-	stats := fmt.Sprintf("sample rate: %d Hz | channels: %d | bitrate: %d kbps",
+	stats := fmt.Sprintf("sample rate: %d Hz | channels: %d | bitrate: %d kbps | volume: %s\n%s | speed: %s | loop: %s",
 		m.qoaPlayer.qoaMetadata.SampleRate,
 		m.qoaPlayer.qoaMetadata.Channels,
-		m.qoaPlayer.bitrate)
+		m.qoaPlayer.bitrate,
+		m.qoaPlayer.settings.describeVolume(),
+		m.songs.order.describe(),
+		m.qoaPlayer.settings.describeSpeed(),
+		m.qoaPlayer.describeLoop())
+	if m.status != "" {
+		stats += "\n" + m.status
+	}
 
 	return statsStyle.Render(stats)
 }
@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// visualMode is what the visualizer shows.
+type visualMode int
+
+const (
+	// visualMeters shows a peak and RMS level meter per channel
+	visualMeters visualMode = iota
+	// visualSpectrum shows the frequency spectrum as bars
+	visualSpectrum
+)
+
+const (
+	// visualFloor is the lowest level shown, in dBFS
+	visualFloor = -60.0
+	// peakHold is how long the peak marks stay put before falling
+	peakHold = 1500 * time.Millisecond
+	// visualFall is how fast peak marks and spectrum bars fall, in dB per second
+	visualFall = 30.0
+	// spectrumSize is the FFT size of the spectrum
+	spectrumSize = 2048
+	// spectrumHeight is the height of the spectrum in lines
+	spectrumHeight = 6
+	// spectrumLow is the lowest frequency in the spectrum
+	spectrumLow = 40.0
+)
+
+// spectrumBlocks draw the tops of the spectrum bars, in eighths of a line.
+var spectrumBlocks = []rune(" ▁▂▃▄▅▆▇█")
+
+// visualizer shows the levels and spectrum of the samples being heard.
+type visualizer struct {
+	mode       visualMode
+	sampleRate int
+	channels   int
+	// samples holds the samples to show, filled by the player
+	samples []int16
+	// peak and rms are the levels of each channel, in dBFS
+	peak, rms []float64
+	// hold is the peak held for each channel, which starts to fall at holdUntil
+	hold      []float64
+	holdUntil []time.Time
+	// spectrum is the level of each frequency bin, in dBFS
+	spectrum []float64
+	analyzer *spectrumAnalyzer
+	mono     []float64
+	updated  time.Time
+}
+
+func newVisualizer(sampleRate, channels int) *visualizer {
+	analyzer, err := newSpectrumAnalyzer(spectrumSize, "hann")
+	if err != nil {
+		logger.Fatalf("Error creating spectrum analyzer: %v", err)
+	}
+	v := &visualizer{
+		sampleRate: sampleRate,
+		channels:   channels,
+		samples:    make([]int16, spectrumSize*channels),
+		peak:       make([]float64, channels),
+		rms:        make([]float64, channels),
+		hold:       make([]float64, channels),
+		holdUntil:  make([]time.Time, channels),
+		spectrum:   make([]float64, spectrumSize/2),
+		analyzer:   analyzer,
+		mono:       make([]float64, spectrumSize),
+	}
+	for c := range channels {
+		v.peak[c], v.rms[c], v.hold[c] = toDBFS(0), toDBFS(0), toDBFS(0)
+	}
+	for i := range v.spectrum {
+		v.spectrum[i] = toDBFS(0)
+	}
+	return v
+}
+
+// toggle switches between the meters and the spectrum.
+func (v *visualizer) toggle() {
+	v.mode = (v.mode + 1) % 2
+}
+
+// update measures samples, the last frames heard. The meters measure the last tick of them.
+func (v *visualizer) update(samples []int16, now time.Time) {
+	dt := 0.0
+	if !v.updated.IsZero() {
+		dt = now.Sub(v.updated).Seconds()
+	}
+	v.updated = now
+	frames := len(samples) / v.channels
+
+	meterFrames := min(frames, v.sampleRate*int(tickInterval)/int(time.Second))
+	for c := range v.channels {
+		peak, sum := 0.0, 0.0
+		for i := frames - meterFrames; i < frames; i++ {
+			s := float64(samples[i*v.channels+c])
+			peak = max(peak, s*s)
+			sum += s * s
+		}
+		v.peak[c] = toDBFS(peak)
+		v.rms[c] = toDBFS(sum / float64(max(meterFrames, 1)))
+
+		// The peak mark holds the loudest peak for a while, then falls
+		if v.peak[c] >= v.hold[c] {
+			v.hold[c], v.holdUntil[c] = v.peak[c], now.Add(peakHold)
+		} else if now.After(v.holdUntil[c]) {
+			v.hold[c] = max(v.peak[c], v.hold[c]-visualFall*dt)
+		}
+	}
+
+	if v.mode != visualSpectrum {
+		return
+	}
+	// The spectrum is of the channels mixed down, with bars falling smoothly
+	offset := spectrumSize - frames
+	for i := range v.mono {
+		v.mono[i] = 0
+		if i >= offset {
+			for c := range v.channels {
+				v.mono[i] += float64(samples[(i-offset)*v.channels+c])
+			}
+			v.mono[i] /= 32768 * float64(v.channels)
+		}
+	}
+	levels := v.analyzer.magnitudesDB(v.mono, nil)
+	for i, level := range levels {
+		v.spectrum[i] = max(level, v.spectrum[i]-visualFall*dt)
+	}
+}
+
+// render draws the meters or the spectrum width cells wide.
+func (v *visualizer) render(width int) string {
+	if v.mode == visualSpectrum {
+		return v.renderSpectrum(width)
+	}
+	return v.renderMeters(width)
+}
+
+// renderMeters draws a line per channel: the RMS level filled in, up to the peak shaded, and
+// the held peak marked.
+func (v *visualizer) renderMeters(width int) string {
+	barStyle := lipgloss.NewStyle().Foreground(main)
+	holdStyle := lipgloss.NewStyle().Foreground(accent)
+	labelWidth, levelWidth := 2, 10
+	barWidth := max(width-labelWidth-levelWidth, 1)
+
+	lines := make([]string, v.channels)
+	for c := range v.channels {
+		rms := levelCells(v.rms[c], barWidth)
+		peak := max(levelCells(v.peak[c], barWidth), rms)
+		hold := levelCells(v.hold[c], barWidth) - 1
+
+		var bar strings.Builder
+		for i := range barWidth {
+			switch {
+			case i == hold && i >= peak:
+				bar.WriteString(holdStyle.Render("|"))
+			case i < rms:
+				bar.WriteString(barStyle.Render("█"))
+			case i < peak:
+				bar.WriteString(barStyle.Render("▒"))
+			default:
+				bar.WriteRune('░')
+			}
+		}
+		level := "-inf dB"
+		if v.hold[c] > visualFloor {
+			level = fmt.Sprintf("%.1f dB", v.hold[c])
+		}
+		lines[c] = fmt.Sprintf("%-*s%s%*s", labelWidth, channelLabel(c, v.channels), bar.String(), levelWidth, level)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderSpectrum draws a bar per cell, each the loudest of a band of frequencies. The bands are
+// spaced evenly in pitch, from spectrumLow up to the Nyquist frequency.
+func (v *visualizer) renderSpectrum(width int) string {
+	nyquist := float64(v.sampleRate) / 2
+	binWidth := float64(v.sampleRate) / spectrumSize
+	eighths := make([]int, width)
+	for i := range eighths {
+		low := spectrumLow * math.Pow(nyquist/spectrumLow, float64(i)/float64(width))
+		high := spectrumLow * math.Pow(nyquist/spectrumLow, float64(i+1)/float64(width))
+		first := int(math.Round(low / binWidth))
+		last := max(int(math.Round(high/binWidth)), first+1)
+		level := toDBFS(0)
+		for _, l := range v.spectrum[min(first, len(v.spectrum)-1):min(last, len(v.spectrum))] {
+			level = max(level, l)
+		}
+		eighths[i] = levelCells(level, spectrumHeight*8)
+	}
+
+	style := lipgloss.NewStyle().Foreground(main)
+	lines := make([]string, spectrumHeight)
+	for row := range lines {
+		var line strings.Builder
+		base := (spectrumHeight - 1 - row) * 8
+		for _, e := range eighths {
+			line.WriteRune(spectrumBlocks[max(0, min(e-base, 8))])
+		}
+		lines[row] = style.Render(line.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// levelCells is how many of cells a level in dBFS fills, from visualFloor to full scale.
+func levelCells(level float64, cells int) int {
+	fraction := (level - visualFloor) / -visualFloor
+	return int(math.Round(max(0, min(fraction, 1)) * float64(cells)))
+}
+
+// channelLabel names channel c of the output, for the meters.
+func channelLabel(c, channels int) string {
+	switch channels {
+	case 1:
+		return "M"
+	case 2:
+		return []string{"L", "R"}[c]
+	}
+	return strconv.Itoa(c + 1)
+}
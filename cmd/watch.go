@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch <in-dir> --out-dir <dir>",
+	Short: "Convert files dropped into a directory",
+	Long: `Watch a directory tree and convert new or modified audio files once they stop changing.
+
+The directory structure of <in-dir> is mirrored in --out-dir. Finished work is recorded
+in a state file, so restarting doesn't convert files again. Files that fail to convert are
+recorded too, and only tried again once they change. The directory is polled, so
+no file system notification support or external service is needed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := watchOptions{inDir: args[0]}
+		opts.outDir, _ = cmd.Flags().GetString("out-dir")
+		opts.to, _ = cmd.Flags().GetString("to")
+		opts.stateFile, _ = cmd.Flags().GetString("state")
+		opts.delete, _ = cmd.Flags().GetBool("delete")
+		opts.settle, _ = cmd.Flags().GetDuration("settle")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		once, _ := cmd.Flags().GetBool("once")
+
+		if !strings.HasPrefix(opts.to, ".") {
+			opts.to = "." + opts.to
+		}
+		if !contains(supportedFormats, opts.to) {
+			logger.Fatalf("Unsupported output format %s", opts.to)
+		}
+		if opts.stateFile == "" {
+			opts.stateFile = filepath.Join(opts.outDir, ".goqoa-watch.json")
+		}
+
+		w, err := newWatcher(opts)
+		if err != nil {
+			logger.Fatalf("Error starting watcher: %v", err)
+		}
+
+		logger.Infof("Watching %s, converting to %s in %s", opts.inDir, opts.to, opts.outDir)
+		for {
+			if err := w.scan(time.Now(), once); err != nil {
+				logger.Errorf("Error scanning %s: %v", opts.inDir, err)
+			}
+			if once {
+				return
+			}
+			time.Sleep(interval)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().String("out-dir", "", "Directory to write converted files to")
+	watchCmd.MarkFlagRequired("out-dir")
+	watchCmd.Flags().String("to", "qoa", "Format to convert to")
+	watchCmd.Flags().String("state", "", "State file recording finished work (default <out-dir>/.goqoa-watch.json)")
+	watchCmd.Flags().Bool("delete", false, "Delete outputs when their source is removed")
+	watchCmd.Flags().Duration("settle", 2*time.Second, "How long a file must stay unchanged before it's converted")
+	watchCmd.Flags().Duration("interval", time.Second, "How often to scan for changes")
+	watchCmd.Flags().Bool("once", false, "Convert everything once and exit, without waiting for files to settle")
+}
+
+type watchOptions struct {
+	inDir, outDir string
+	// to is the output file extension
+	to        string
+	stateFile string
+	delete    bool
+	settle    time.Duration
+}
+
+// watchEntry records the source file a conversion was done from.
+type watchEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Output  string    `json:"output"`
+	// Error is why the conversion failed. Output is then that of an earlier conversion, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// pendingFile is a source that changed and is waiting to settle.
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	// since is when size and modTime were first seen
+	since time.Time
+}
+
+type watcher struct {
+	opts watchOptions
+	// done maps source paths, relative to inDir, to their finished or failed conversion
+	done    map[string]watchEntry
+	pending map[string]pendingFile
+}
+
+func newWatcher(opts watchOptions) (*watcher, error) {
+	if err := os.MkdirAll(opts.outDir, 0o755); err != nil {
+		return nil, err
+	}
+	w := &watcher{opts: opts, done: make(map[string]watchEntry), pending: make(map[string]pendingFile)}
+
+	data, err := os.ReadFile(opts.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &w.done); err != nil {
+		return nil, fmt.Errorf("reading state file %s: %w", opts.stateFile, err)
+	}
+	return w, nil
+}
+
+// scan converts every source that changed and has settled. With force, sources don't need to settle.
+func (w *watcher) scan(now time.Time, force bool) error {
+	outDir, err := filepath.Abs(w.opts.outDir)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	err = filepath.Walk(w.opts.inDir, func(path string, info os.FileInfo, err error) error {
+		if errors.Is(err, os.ErrNotExist) && path != w.opts.inDir {
+			// Removed while scanning, so it's stale like any other removed source
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// Don't convert our own output when it's inside the watched directory, unless it's
+			// the watched directory itself
+			if abs, err := filepath.Abs(path); err == nil && abs == outDir && path != w.opts.inDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(w.opts.inDir, path)
+		if err != nil {
+			return err
+		}
+		output := filepath.Join(w.opts.outDir, strings.TrimSuffix(rel, filepath.Ext(rel))+w.opts.to)
+		if strings.HasPrefix(info.Name(), ".") || !isSupportedConversion(path, output) {
+			return nil
+		}
+		seen[rel] = true
+
+		if entry, ok := w.done[rel]; ok && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+			return nil
+		}
+
+		p, ok := w.pending[rel]
+		if !ok || p.size != info.Size() || !p.modTime.Equal(info.ModTime()) {
+			// New or still changing
+			p = pendingFile{size: info.Size(), modTime: info.ModTime(), since: now}
+			w.pending[rel] = p
+		}
+		if !force && (now.Sub(p.since) < w.opts.settle || now.Sub(info.ModTime()) < w.opts.settle) {
+			return nil
+		}
+
+		delete(w.pending, rel)
+		entry := watchEntry{Size: info.Size(), ModTime: info.ModTime(), Output: output}
+		if err := w.convert(path, output); err != nil {
+			logger.Errorf("Error converting %s: %v", path, err)
+			// Don't try again until the file changes
+			entry.Output, entry.Error = w.done[rel].Output, err.Error()
+		}
+		w.done[rel] = entry
+		// Saving after every file keeps finished work across a restart, but failing to shouldn't
+		// hold up the other files
+		if err := w.saveState(); err != nil {
+			logger.Errorf("Error saving %s: %v", w.opts.stateFile, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for rel := range w.pending {
+		if !seen[rel] {
+			delete(w.pending, rel)
+		}
+	}
+	return w.removeStale(seen)
+}
+
+// convert writes to a temporary file first so readers of the output directory never see partial files.
+func (w *watcher) convert(input, output string) error {
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return err
+	}
+	decodedData, q, err := decodeAudio(input)
+	if err != nil {
+		return err
+	}
+	temp := filepath.Join(filepath.Dir(output), "."+strings.TrimSuffix(filepath.Base(output), w.opts.to)+".partial"+w.opts.to)
+	if err := encodeAudio(temp, q, decodedData); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, output); err != nil {
+		return err
+	}
+	logger.Infof("Converted %s -> %s", input, output)
+	return nil
+}
+
+// removeStale forgets sources that are gone, deleting their outputs if enabled.
+func (w *watcher) removeStale(seen map[string]bool) error {
+	var removed []string
+	for rel := range w.done {
+		if !seen[rel] {
+			removed = append(removed, rel)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	sort.Strings(removed)
+
+	for _, rel := range removed {
+		if output := w.done[rel].Output; w.opts.delete && output != "" {
+			if err := os.Remove(output); err != nil && !errors.Is(err, os.ErrNotExist) {
+				logger.Errorf("Error removing %s: %v", output, err)
+				continue
+			}
+			logger.Infof("Removed %s", output)
+		}
+		delete(w.done, rel)
+	}
+	return w.saveState()
+}
+
+func (w *watcher) saveState() error {
+	data, err := json.MarshalIndent(w.done, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp := w.opts.stateFile + ".tmp"
+	if err := os.WriteFile(temp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, w.opts.stateFile)
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/braheezy/qoa"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// overviewBuckets is how many peaks an overview keeps, however long the song is
+	overviewBuckets = 2048
+	// waveformHeight is the height of the waveform in lines
+	waveformHeight = 2
+)
+
+// computeOverview decodes a QOA file and returns the peaks of up to overviewBuckets even
+// stretches of it, across all channels.
+func computeOverview(filename string) ([]int16, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	source, err := newQOAStreamSource(f)
+	if err != nil {
+		return nil, err
+	}
+
+	frames, channels := source.Frames(), source.Channels()
+	bucket := max((frames+overviewBuckets-1)/overviewBuckets, 1)
+	overview := make([]int16, (frames+bucket-1)/bucket)
+	buf := make([]int16, qoa.QOAFrameLen*channels)
+	for start := 0; start < frames; {
+		n, err := source.ReadFrames(buf, start)
+		if err != nil {
+			return nil, err
+		}
+		for i, s := range buf[:n*channels] {
+			// -32768 has no positive counterpart
+			peak := max(s, -32767)
+			if peak < 0 {
+				peak = -peak
+			}
+			b := (start + i/channels) / bucket
+			overview[b] = max(overview[b], peak)
+		}
+		start += n
+	}
+	return overview, nil
+}
+
+// overviews computes the overviews of songs in the background, and keeps them for when a
+// song plays again.
+type overviews struct {
+	mu   sync.Mutex
+	done map[string][]int16
+}
+
+func newOverviews() *overviews {
+	return &overviews{done: map[string][]int16{}}
+}
+
+// get returns the overview of filename, or false if it isn't ready yet. The first call starts
+// computing it. A song that can't be decoded has an empty overview.
+func (o *overviews) get(filename string) ([]int16, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	overview, ok := o.done[filename]
+	if !ok {
+		o.done[filename] = nil
+		go func() {
+			overview, err := computeOverview(filename)
+			if err != nil {
+				overview = []int16{}
+			}
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			o.done[filename] = overview
+		}()
+	}
+	return overview, overview != nil
+}
+
+// waveformColumns fits an overview to width columns, returning how many eighths of the
+// waveform's height each column fills.
+func waveformColumns(overview []int16, width int) []int {
+	columns := make([]int, width)
+	for i := range columns {
+		peak := int16(0)
+		if len(overview) > 0 {
+			first := i * len(overview) / width
+			last := max((i+1)*len(overview)/width, first+1)
+			for _, p := range overview[first:min(last, len(overview))] {
+				peak = max(peak, p)
+			}
+		}
+		// Every column shows, so silence still draws a line
+		columns[i] = max(levelCells(toDBFS(float64(peak)*float64(peak)), waveformHeight*8), 1)
+	}
+	return columns
+}
+
+// drawWaveform draws the waveform columns, highlighted up to the played fraction. The column
+// cursor, if not -1, is marked, as are the columns from regionStart to regionEnd.
+func drawWaveform(columns []int, played float64, cursor, regionStart, regionEnd int) string {
+	// Played, still to play and cursor columns, then played and still to play in the region
+	styles := []lipgloss.Style{
+		lipgloss.NewStyle().Foreground(main),
+		lipgloss.NewStyle().Faint(true),
+		lipgloss.NewStyle().Foreground(accent).Reverse(true),
+		lipgloss.NewStyle().Foreground(main).Background(region),
+		lipgloss.NewStyle().Foreground(accent).Background(region),
+	}
+	playedColumns := int(played*float64(len(columns)) + 0.5)
+
+	lines := make([]string, waveformHeight)
+	for row := range lines {
+		base := (waveformHeight - 1 - row) * 8
+		var line strings.Builder
+		// Columns are styled in runs, to keep the escape codes down
+		var run strings.Builder
+		runStyle := -1
+		for i, c := range columns {
+			style := 1
+			switch {
+			case i == cursor:
+				style = 2
+			case i >= regionStart && i <= regionEnd && i < playedColumns:
+				style = 3
+			case i >= regionStart && i <= regionEnd:
+				style = 4
+			case i < playedColumns:
+				style = 0
+			}
+			if style != runStyle && run.Len() > 0 {
+				line.WriteString(styles[runStyle].Render(run.String()))
+				run.Reset()
+			}
+			runStyle = style
+			run.WriteRune(spectrumBlocks[max(0, min(c-base, 8))])
+		}
+		if run.Len() > 0 {
+			line.WriteString(styles[runStyle].Render(run.String()))
+		}
+		lines[row] = line.String()
+	}
+	return strings.Join(lines, "\n")
+}